@@ -0,0 +1,270 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package chaos implements a proxy injecting faults into MQTT traffic,
+// for exercising reconnect and QoS code paths.
+//
+// Rules apply to packets of a type and are written as
+//     type:action[:arg][@probability]
+// where type is a packet name like "puback", or "any", and action is
+// - delay:duration    forwards the packet after the duration
+// - drop              doesn't forward the packet
+// - dup               forwards the packet twice
+// - truncate          forwards half of the packet and closes the connection
+// - corrupt           forwards the packet with an invalid remaining length
+// - close:n           closes the connection on the n-th packet
+// and the probability is above 0 and at most 1, which is the default.
+// e.g. "puback:drop@0.5", "publish:dup", "any:delay:100ms", "any:close:20".
+//
+// Each direction of each connection uses its own random source derived
+// from the seed and the number of the connection in accepting order, so
+// the same seed and order of connecting reproduce the same faults.
+package chaos
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "bufio"
+    "bytes"
+    "strconv"
+    "strings"
+    "math/rand"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+const (
+    ActDelay Action = iota
+    ActDrop
+    ActDup
+    ActTruncate
+    ActCorrupt
+    ActClose
+    )
+
+// Any packet type
+const AnyType mqttgo.MsgType = 0
+
+var actionNames = []string{"delay", "drop", "dup", "truncate", "corrupt", "close"}
+
+var typeNames = []string{"any", "connect", "connack", "publish", "puback",
+    "pubrec", "pubrel", "pubcomp", "subscribe", "suback", "unsubscribe",
+    "unsuback", "pingreq", "pingresp", "disconnect"}
+
+type Action uint8
+
+// The name of a packet type as used in rules
+func TypeName(t mqttgo.MsgType) string {
+    if int(t) < len(typeNames) {
+        return typeNames[t]
+    }
+    return "invalid"
+}
+
+func (a Action) String() string {
+    return actionNames[a]
+}
+
+// A fault to inject
+type Rule struct {
+    Type    mqttgo.MsgType  // AnyType for all packets
+    Action  Action
+    Delay   time.Duration   // For ActDelay
+    N       int             // For ActClose
+    Prob    float64         // Probability to apply, 1 if zero
+}
+
+// Parses a rule written as type:action[:arg][@probability]
+func ParseRule(s string) (Rule, error) {
+    var r Rule
+    spec, prob, hasProb := strings.Cut(s, "@")
+    if hasProb {
+        p, err := strconv.ParseFloat(prob, 64)
+        if err != nil || p <= 0 || p > 1 {
+            return r, fmt.Errorf("chaos: bad probability in rule %q", s)
+        }
+        r.Prob = p
+    }
+    parts := strings.SplitN(spec, ":", 3)
+    if len(parts) < 2 {
+        return r, fmt.Errorf("chaos: rule %q must be type:action[:arg]", s)
+    }
+    t := index(typeNames, parts[0])
+    if t == len(typeNames) {
+        return r, fmt.Errorf("chaos: unknown packet type in rule %q", s)
+    }
+    r.Type = mqttgo.MsgType(t)
+    a := index(actionNames, parts[1])
+    if a == len(actionNames) {
+        return r, fmt.Errorf("chaos: unknown action in rule %q", s)
+    }
+    r.Action = Action(a)
+    var err error
+    switch r.Action {
+    case ActDelay:
+        if len(parts) == 3 {
+            r.Delay, err = time.ParseDuration(parts[2])
+        } else {
+            err = fmt.Errorf("chaos: delay needs a duration")
+        }
+    case ActClose:
+        if len(parts) == 3 {
+            r.N, err = strconv.Atoi(parts[2])
+        } else {
+            err = fmt.Errorf("chaos: close needs a packet count")
+        }
+    }
+    return r, err
+}
+
+func index(names []string, name string) int {
+    for i, n := range names {
+        if n == strings.ToLower(name) {
+            return i
+        }
+    }
+    return len(names)
+}
+
+func (r Rule) String() string {
+    s := TypeName(r.Type) + ":" + r.Action.String()
+    if r.Action == ActDelay {
+        s += ":" + r.Delay.String()
+    } else if r.Action == ActClose {
+        s += ":" + strconv.Itoa(r.N)
+    }
+    if r.Prob != 0 {
+        s += "@" + strconv.FormatFloat(r.Prob, 'g', -1, 64)
+    }
+    return s
+}
+
+// Proxies MQTT connections to a broker, injecting faults by the rules
+type Proxy struct {
+    Broker  string  // Broker address
+    Seed    int64
+    Rules   []Rule
+    // Called for every fault injected, could be nil
+    OnFault func(conn int, toBroker bool, r Rule, m mqttgo.Msg)
+    mu      sync.Mutex
+    conns   int
+}
+
+// Accepts connections on l and proxies them until l is closed.
+// Connections are numbered in the order they are accepted.
+func (p *Proxy) Serve(l net.Listener) error {
+    for {
+        c, err := l.Accept()
+        if err != nil {
+            return err
+        }
+        p.mu.Lock()
+        n := p.conns
+        p.conns++
+        p.mu.Unlock()
+        go p.ServeConn(c, n)
+    }
+}
+
+// Proxies the client connection c numbered n, which selects the random sources
+func (p *Proxy) ServeConn(c net.Conn, n int) {
+    defer c.Close()
+    b, err := net.Dial("tcp", p.Broker)
+    if err != nil {
+        return
+    }
+    defer b.Close()
+    done := make(chan struct{}, 2)
+    go func() { p.Pipe(c, b, n, true); done <- struct{}{} }()
+    go func() { p.Pipe(b, c, n, false); done <- struct{}{} }()
+    <-done
+}
+
+// Forwards packets from src to dst, applying the rules.
+// conn numbers the connection, which selects the random source.
+func (p *Proxy) Pipe(src net.Conn, dst net.Conn, conn int, toBroker bool) {
+    defer src.Close()
+    defer dst.Close()
+    seed := p.Seed + int64(conn) * 2
+    if !toBroker {
+        seed++
+    }
+    rng := rand.New(rand.NewSource(seed))
+    counts := make([]int, len(p.Rules))
+    var frame bytes.Buffer
+    r := io.TeeReader(bufio.NewReader(src), &frame)
+    for {
+        frame.Reset()
+        m, err := mqttgo.Read(r)
+        if err != nil {
+            return
+        }
+        if !p.apply(m, frame.Bytes(), dst, conn, toBroker, rng, counts) {
+            return
+        }
+    }
+}
+
+// Forwards one packet, returns false if the connection is to be closed
+func (p *Proxy) apply(m mqttgo.Msg, frame []byte, dst io.Writer, conn int,
+    toBroker bool, rng *rand.Rand, counts []int) bool {
+    writes := 1
+    for i, rule := range p.Rules {
+        if rule.Type != AnyType && rule.Type != m.MsgHeader().Type() {
+            continue
+        }
+        counts[i]++
+        // Draw even if unused, so the sequence depends only on the packets
+        if draw := rng.Float64(); rule.Prob != 0 && draw >= rule.Prob {
+            continue
+        }
+        if rule.Action == ActClose && counts[i] < rule.N {
+            continue
+        }
+        if p.OnFault != nil {
+            p.OnFault(conn, toBroker, rule, m)
+        }
+        switch rule.Action {
+        case ActDelay:
+            time.Sleep(rule.Delay)
+        case ActDrop:
+            writes = 0
+        case ActDup:
+            writes++
+        case ActTruncate:
+            dst.Write(frame[:len(frame) / 2])
+            return false
+        case ActCorrupt:
+            // Four bytes all with the continuation bit is never valid
+            l := 1
+            for frame[l] & 0x80 != 0 {
+                l++
+            }
+            corrupt := append([]byte{frame[0], 0xff, 0xff, 0xff, 0xff}, frame[l + 1:]...)
+            _, err := dst.Write(corrupt)
+            return err == nil
+        case ActClose:
+            return false
+        }
+    }
+    for ; writes > 0; writes-- {
+        if _, err := dst.Write(frame); err != nil {
+            return false
+        }
+    }
+    return true
+}
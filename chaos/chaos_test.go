@@ -0,0 +1,138 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package chaos
+
+import (
+    "bytes"
+    "testing"
+    "math/rand"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+func TestParseRule(t *testing.T) {
+    for _, c := range []struct {
+        s       string
+        want    string  // "" for an error
+    }{
+        {"puback:drop@0.5", "puback:drop@0.5"},
+        {"PUBLISH:dup", "publish:dup"},
+        {"any:delay:100ms", "any:delay:100ms"},
+        {"any:close:20@1", "any:close:20@1"},
+        {"disconnect:truncate", "disconnect:truncate"},
+        {"puback:drop@0", ""},
+        {"puback:drop@1.5", ""},
+        {"puback:drop@x", ""},
+        {"invalid:drop", ""},
+        {"reserved:drop", ""},
+        {"puback:explode", ""},
+        {"any:delay", ""},
+        {"any:close:x", ""},
+        {"puback", ""},
+    } {
+        r, err := ParseRule(c.s)
+        if c.want == "" {
+            if err == nil {
+                t.Errorf("%q: parsed as %v, want an error", c.s, r)
+            }
+        } else if err != nil || r.String() != c.want {
+            t.Errorf("%q: parsed as %v, %v, want %s", c.s, r, err, c.want)
+        }
+    }
+}
+
+func encode(t *testing.T, m mqttgo.Msg) []byte {
+    var b bytes.Buffer
+    if err := mqttgo.Write(&b, m); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+// Applies the rules to 200 PUBLISH, returns the faults and the output
+func run(t *testing.T, seed int64, rules ...string) ([]string, []byte) {
+    var faults []string
+    p := &Proxy{Seed: seed, OnFault: func(conn int, toBroker bool, r Rule, m mqttgo.Msg) {
+        faults = append(faults, r.String() + " " + string(m.(*mqttgo.MsgPublish).Content))
+    }}
+    for _, s := range rules {
+        r, err := ParseRule(s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        p.Rules = append(p.Rules, r)
+    }
+    var out bytes.Buffer
+    rng := rand.New(rand.NewSource(seed))
+    counts := make([]int, len(p.Rules))
+    for i := 0; i < 200; i++ {
+        m := mqttgo.NewPub("a/b", mqttgo.QosAtMostOnce, []byte{byte(i)})
+        if !p.apply(m, encode(t, m), &out, 0, true, rng, counts) {
+            break
+        }
+    }
+    return faults, out.Bytes()
+}
+
+func TestApplyReproducible(t *testing.T) {
+    rules := []string{"publish:drop@0.3", "any:dup@0.2", "connect:drop"}
+    f1, out1 := run(t, 42, rules...)
+    f2, out2 := run(t, 42, rules...)
+    if len(f1) == 0 || len(f1) == 200 {
+        t.Fatalf("%d faults in 200 packets", len(f1))
+    }
+    if len(f1) != len(f2) || !bytes.Equal(out1, out2) {
+        t.Fatalf("same seed gave %d and %d faults", len(f1), len(f2))
+    }
+    for i := range f1 {
+        if f1[i] != f2[i] {
+            t.Fatalf("fault %d is %s and %s with the same seed", i, f1[i], f2[i])
+        }
+    }
+    if _, out3 := run(t, 43, rules...); bytes.Equal(out1, out3) {
+        t.Error("another seed gave the same output")
+    }
+}
+
+func TestApplyBrokenPackets(t *testing.T) {
+    m := mqttgo.NewPub("a/b", mqttgo.QosAtMostOnce, bytes.Repeat([]byte{'x'}, 200))
+    frame := encode(t, m)
+    // 200 + 5 bytes remaining, encoded in two bytes
+    if frame[1] & 0x80 == 0 || frame[2] & 0x80 != 0 {
+        t.Fatalf("remaining length encoded as % x", frame[1:3])
+    }
+
+    var b bytes.Buffer
+    if (&Proxy{Rules: []Rule{{Type: mqttgo.MsgTypePublish, Action: ActTruncate}}}).apply(
+        m, frame, &b, 0, true, rand.New(rand.NewSource(1)), make([]int, 1)) {
+        t.Error("truncate didn't close the connection")
+    }
+    if !bytes.Equal(b.Bytes(), frame[:len(frame) / 2]) {
+        t.Errorf("truncate wrote %d of %d bytes, want half", b.Len(), len(frame))
+    }
+
+    b.Reset()
+    if !(&Proxy{Rules: []Rule{{Type: AnyType, Action: ActCorrupt}}}).apply(
+        m, frame, &b, 0, true, rand.New(rand.NewSource(1)), make([]int, 1)) {
+        t.Error("corrupt closed the connection")
+    }
+    want := append([]byte{frame[0], 0xff, 0xff, 0xff, 0xff}, frame[3:]...)
+    if !bytes.Equal(b.Bytes(), want) {
+        t.Errorf("corrupt wrote % x, want % x", b.Bytes()[:8], want[:8])
+    }
+    if _, err := mqttgo.Read(&b); err == nil {
+        t.Error("corrupted packet decodes")
+    }
+}
@@ -0,0 +1,246 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package mqttcli implements the connection handling shared by the
+// mqttgo command-line tools: flags, dialing (optionally over TLS),
+// the CONNECT/CONNACK handshake and keep alive.
+package mqttcli
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "flag"
+    "bufio"
+    "errors"
+    "strconv"
+    "crypto/tls"
+    "crypto/x509"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Connection options, set by flags
+type Options struct {
+    Host        string
+    Port        int
+    ClientId    string
+    Username    string
+    Password    string
+    KeepAlive   int
+    NoClean     bool                // Keep the session, -c as in mosquitto_sub
+    TLS         bool
+    CAFile      string
+    CertFile    string
+    KeyFile     string
+    Insecure    bool
+    WillTopic   string
+    WillPayload string
+    WillQos     int
+    WillRetain  bool
+}
+
+// Registers the connection flags on fs
+func (o *Options) Register(fs *flag.FlagSet) {
+    fs.StringVar(&o.Host, "h", "localhost", "broker host")
+    fs.IntVar(&o.Port, "p", 0, "broker port, 1883 or 8883 with -tls")
+    fs.StringVar(&o.ClientId, "i", "", "client id, generated if empty")
+    fs.StringVar(&o.Username, "u", "", "username")
+    fs.StringVar(&o.Password, "P", "", "password")
+    fs.IntVar(&o.KeepAlive, "k", 60, "keep alive in seconds")
+    fs.BoolVar(&o.NoClean, "c", false, "disable clean session, the broker keeps the session")
+    fs.BoolVar(&o.TLS, "tls", false, "connect over TLS")
+    fs.StringVar(&o.CAFile, "cafile", "", "CA certificates for verifying the broker (PEM)")
+    fs.StringVar(&o.CertFile, "cert", "", "client certificate (PEM)")
+    fs.StringVar(&o.KeyFile, "key", "", "client private key (PEM)")
+    fs.BoolVar(&o.Insecure, "insecure", false, "don't verify the broker certificate")
+    fs.StringVar(&o.WillTopic, "will-topic", "", "will topic")
+    fs.StringVar(&o.WillPayload, "will-payload", "", "will payload")
+    fs.IntVar(&o.WillQos, "will-qos", 0, "will QoS level")
+    fs.BoolVar(&o.WillRetain, "will-retain", false, "retain the will")
+}
+
+// A connection to the broker
+type Conn struct {
+    conn    net.Conn
+    r       *bufio.Reader
+    wmu     sync.Mutex
+    idmu    sync.Mutex
+    nextId  uint16
+    done    chan struct{}
+}
+
+// Dials the broker, sends CONNECT and waits for CONNACK
+func (o *Options) Connect() (*Conn, error) {
+    m, err := o.connectMsg()
+    if err != nil {
+        return nil, err
+    }
+    port := o.Port
+    if port == 0 {
+        port = 1883
+        if o.TLS {
+            port = 8883
+        }
+    }
+    addr := net.JoinHostPort(o.Host, strconv.Itoa(port))
+    var nc net.Conn
+    if o.TLS {
+        var cfg *tls.Config
+        if cfg, err = o.tlsConfig(); err != nil {
+            return nil, err
+        }
+        nc, err = tls.Dial("tcp", addr, cfg)
+    } else {
+        nc, err = net.Dial("tcp", addr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    c := &Conn{conn: nc, r: bufio.NewReader(nc), done: make(chan struct{})}
+    if err = c.Write(m); err == nil {
+        err = c.handshake()
+    }
+    if err != nil {
+        nc.Close()
+        return nil, err
+    }
+    if o.KeepAlive > 0 {
+        go c.keepAlive(time.Duration(o.KeepAlive) * time.Second)
+    }
+    return c, nil
+}
+
+func (o *Options) connectMsg() (*mqttgo.MsgConnect, error) {
+    id := o.ClientId
+    if id == "" {
+        id = fmt.Sprintf("mqttgo-%d", os.Getpid())
+    }
+    m := mqttgo.NewConnect(id, uint16(o.KeepAlive))
+    m.SetCleanSession(!o.NoClean)
+    if o.WillTopic != "" {
+        m.SetWillFlag(true)
+        m.WillTopic = o.WillTopic
+        m.WillMsg = o.WillPayload
+        m.SetWillRetain(o.WillRetain)
+        if err := m.SetWillQos(mqttgo.QosLevel(o.WillQos)); err != nil {
+            return nil, err
+        }
+    }
+    if o.Username != "" {
+        m.SetUserNameFlag(true)
+        m.UserName = o.Username
+    }
+    if o.Password != "" {
+        if o.Username == "" {
+            // MQTT-3.1.2-22
+            return nil, errors.New("a password needs a username")
+        }
+        m.SetPasswordFlag(true)
+        m.Password = o.Password
+    }
+    return m, nil
+}
+
+func (o *Options) tlsConfig() (*tls.Config, error) {
+    cfg := &tls.Config{ServerName: o.Host, InsecureSkipVerify: o.Insecure}
+    if o.CAFile != "" {
+        pem, err := os.ReadFile(o.CAFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.RootCAs = x509.NewCertPool()
+        if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+            return nil, errors.New("no certificates found in " + o.CAFile)
+        }
+    }
+    if o.CertFile != "" || o.KeyFile != "" {
+        cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.Certificates = []tls.Certificate{cert}
+    }
+    return cfg, nil
+}
+
+func (c *Conn) handshake() error {
+    m, err := c.Read()
+    if err != nil {
+        return err
+    }
+    ack, ok := m.(*mqttgo.MsgConnAck)
+    if !ok {
+        return fmt.Errorf("expected CONNACK, got message type %d", m.MsgHeader().Type())
+    } else if ack.RC != mqttgo.RCAccepted {
+        return RefusedError{ack.RC}
+    }
+    return nil
+}
+
+// The broker refused the connection with the return code
+type RefusedError struct {
+    RC  mqttgo.ReturnCode
+}
+
+func (e RefusedError) Error() string {
+    return fmt.Sprintf("connection refused, return code %d", e.RC)
+}
+
+// Sends PINGREQ every interval until the connection is closed
+func (c *Conn) keepAlive(interval time.Duration) {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+        select {
+        case <-c.done:
+            return
+        case <-t.C:
+            if c.Write(mqttgo.NewPingReq()) != nil {
+                return
+            }
+        }
+    }
+}
+
+// Reads the next message
+func (c *Conn) Read() (mqttgo.Msg, error) {
+    return mqttgo.Read(c.r)
+}
+
+// Writes m, safe for concurrent use
+func (c *Conn) Write(m mqttgo.Msg) error {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    return mqttgo.Write(c.conn, m)
+}
+
+// Returns the next message id, never zero
+func (c *Conn) NextId() uint16 {
+    c.idmu.Lock()
+    defer c.idmu.Unlock()
+    if c.nextId++; c.nextId == 0 {
+        c.nextId++
+    }
+    return c.nextId
+}
+
+// Sends DISCONNECT and closes the connection
+func (c *Conn) Close() error {
+    close(c.done)
+    c.Write(mqttgo.NewDisconnect())
+    return c.conn.Close()
+}
@@ -0,0 +1,322 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttbench generates load on a broker and reports throughput,
+// end-to-end latency, connect times and errors.
+//
+// Publishers put their send time in the first 8 bytes of each payload,
+// subscribers measure the latency when the message arrives.
+// A QoS 1/2 publisher waits when it has -inflight messages not yet
+// acknowledged, so packet ids are never reused while in use.
+//
+// Usage:
+//     mqttbench [-n clients] [-s subscribers] [-rate msgs/sec] [-size bytes]
+//         [-q qos] [-inflight n] [-d duration] [-t topic prefix] [flags]
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "flag"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "strconv"
+    "encoding/binary"
+    "github.com/oxfeeefeee/mqttgo"
+    "github.com/oxfeeefeee/mqttgo/cmd/internal/mqttcli"
+    )
+
+// Results collected from all connections
+type stats struct {
+    mu          sync.Mutex
+    connTimes   []time.Duration
+    latencies   []time.Duration
+    published   int
+    received    int
+    errors      map[string]int
+}
+
+func (s *stats) addError(err error) {
+    var refused mqttcli.RefusedError
+    key := err.Error()
+    if errors.As(err, &refused) {
+        key = "return code " + strconv.Itoa(int(refused.RC))
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.errors[key]++
+}
+
+// Connects with the client id, recording the connect time or the error
+func (s *stats) connect(opts mqttcli.Options, id string) *mqttcli.Conn {
+    opts.ClientId = id
+    start := time.Now()
+    c, err := opts.Connect()
+    if err != nil {
+        s.addError(err)
+        return nil
+    }
+    s.mu.Lock()
+    s.connTimes = append(s.connTimes, time.Since(start))
+    s.mu.Unlock()
+    return c
+}
+
+// Packet ids of QoS 1/2 publishes waiting for PUBACK or PUBCOMP
+type inflight struct {
+    mu      sync.Mutex
+    ids     map[uint16]bool
+    slots   chan struct{}
+}
+
+func newInflight(n int) *inflight {
+    return &inflight{ids: make(map[uint16]bool), slots: make(chan struct{}, n)}
+}
+
+// Waits for a free slot until deadline, returns a packet id not in flight,
+// or false if the deadline passed
+func (f *inflight) acquire(c *mqttcli.Conn, deadline time.Time) (uint16, bool) {
+    select {
+    case f.slots <- struct{}{}:
+    default:
+        t := time.NewTimer(time.Until(deadline))
+        defer t.Stop()
+        select {
+        case f.slots <- struct{}{}:
+        case <-t.C:
+            return 0, false
+        }
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    id := c.NextId()
+    for f.ids[id] {
+        id = c.NextId()
+    }
+    f.ids[id] = true
+    return id, true
+}
+
+// Frees the slot of id, once acknowledged
+func (f *inflight) release(id uint16) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.ids[id] {
+        delete(f.ids, id)
+        <-f.slots
+    }
+}
+
+func main() {
+    var opts mqttcli.Options
+    opts.Register(flag.CommandLine)
+    clients := flag.Int("n", 10, "number of publishing connections")
+    subs := flag.Int("s", 1, "number of subscribing connections")
+    rate := flag.Float64("rate", 1000, "total messages per second, 0 for unlimited")
+    size := flag.Int("size", 64, "payload size in bytes, at least 8")
+    qos := flag.Int("q", 0, "QoS level")
+    window := flag.Int("inflight", 20, "unacknowledged QoS 1/2 messages per publisher")
+    duration := flag.Duration("d", 10 * time.Second, "duration of publishing")
+    prefix := flag.String("t", "mqttbench", "topic prefix")
+    flag.Parse()
+
+    if !mqttgo.QosLevel(*qos).Valid() {
+        fmt.Fprintln(os.Stderr, "mqttbench:", mqttgo.ErrBadQosLevel)
+        os.Exit(1)
+    }
+    if *size < 8 {
+        *size = 8
+    }
+    if *window < 1 || *window > 0xffff {
+        fmt.Fprintln(os.Stderr, "mqttbench: -inflight must be between 1 and 65535")
+        os.Exit(1)
+    }
+    s := &stats{errors: make(map[string]int)}
+    var subWg, subscribed sync.WaitGroup
+    var subConns []*mqttcli.Conn
+    for i := 0; i < *subs; i++ {
+        if c := s.connect(opts, fmt.Sprintf("mqttbench-sub-%d", i)); c != nil {
+            subConns = append(subConns, c)
+            subWg.Add(1)
+            subscribed.Add(1)
+            go func() {
+                defer subWg.Done()
+                subscribe(c, *prefix + "/#", mqttgo.QosLevel(*qos), s, subscribed.Done)
+            }()
+        }
+    }
+    subscribed.Wait() // Messages published before SUBACK could be lost
+
+    start := time.Now()
+    var pubWg sync.WaitGroup
+    for i := 0; i < *clients; i++ {
+        var interval time.Duration
+        if *rate > 0 {
+            interval = time.Duration(float64(*clients) / *rate * float64(time.Second))
+        }
+        topic := *prefix + "/" + strconv.Itoa(i)
+        id := fmt.Sprintf("mqttbench-pub-%d", i)
+        pubWg.Add(1)
+        go func() {
+            defer pubWg.Done()
+            if c := s.connect(opts, id); c != nil {
+                publish(c, topic, mqttgo.QosLevel(*qos), *size, *window, interval, start.Add(*duration), s)
+            }
+        }()
+    }
+    pubWg.Wait()
+    elapsed := time.Since(start)
+    time.Sleep(time.Second) // Let in flight messages arrive
+    for _, c := range subConns {
+        c.Close()
+    }
+    subWg.Wait()
+    s.report(elapsed)
+}
+
+// Publishes every interval until deadline, with at most window QoS 1/2
+// messages in flight
+func publish(c *mqttcli.Conn, topic string, qos mqttgo.QosLevel, size int, window int,
+    interval time.Duration, deadline time.Time, s *stats) {
+    defer c.Close()
+    f := newInflight(window)
+    go func() { // Completes the QoS flows
+        for {
+            m, err := c.Read()
+            if err != nil {
+                if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+                    s.addError(err)
+                }
+                return
+            }
+            switch m := m.(type) {
+            case *mqttgo.MsgPubAck:
+                f.release(m.MsgId)
+            case *mqttgo.MsgPubRec:
+                c.Write(mqttgo.NewPubRel(m.MsgId))
+            case *mqttgo.MsgPubComp:
+                f.release(m.MsgId)
+            }
+        }
+    }()
+    next := time.Now()
+    for time.Now().Before(deadline) {
+        p := make([]byte, size)
+        binary.BigEndian.PutUint64(p, uint64(time.Now().UnixNano()))
+        m := mqttgo.NewPub(topic, qos, p)
+        if qos > mqttgo.QosAtMostOnce {
+            id, ok := f.acquire(c, deadline)
+            if !ok {
+                return
+            }
+            m.MsgId = id
+        }
+        if err := c.Write(m); err != nil {
+            s.addError(err)
+            return
+        }
+        s.mu.Lock()
+        s.published++
+        s.mu.Unlock()
+        if interval > 0 {
+            next = next.Add(interval)
+            time.Sleep(time.Until(next))
+        }
+    }
+}
+
+// Receives until the connection is closed, recording the latencies.
+// Calls subscribed once SUBACK is received, or on failing before that.
+func subscribe(c *mqttcli.Conn, filter string, qos mqttgo.QosLevel, s *stats, subscribed func()) {
+    acked := false
+    defer func() {
+        if !acked {
+            subscribed()
+        }
+    }()
+    if err := c.Write(mqttgo.NewSubscribe(c.NextId(), qos, filter)); err != nil {
+        s.addError(err)
+        return
+    }
+    for {
+        m, err := c.Read()
+        if err != nil {
+            if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+                s.addError(err)
+            }
+            return
+        }
+        switch m := m.(type) {
+        case *mqttgo.MsgSubAck:
+            if !acked {
+                acked = true
+                subscribed()
+            }
+        case *mqttgo.MsgPubRel:
+            c.Write(mqttgo.NewPubComp(m.MsgId))
+        case *mqttgo.MsgPublish:
+            if q, _ := m.H.Qos(); q == mqttgo.QosAtLeastOnce {
+                c.Write(mqttgo.NewPubAck(m.MsgId))
+            } else if q == mqttgo.QosExactlyOnce {
+                c.Write(mqttgo.NewPubRec(m.MsgId))
+            }
+            if len(m.Content) < 8 {
+                continue
+            }
+            sent := time.Unix(0, int64(binary.BigEndian.Uint64(m.Content)))
+            s.mu.Lock()
+            s.received++
+            s.latencies = append(s.latencies, time.Since(sent))
+            s.mu.Unlock()
+        }
+    }
+}
+
+func (s *stats) report(elapsed time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    secs := elapsed.Seconds()
+    fmt.Printf("duration:     %v\n", elapsed.Round(time.Millisecond))
+    fmt.Printf("connections:  %d\n", len(s.connTimes))
+    printDurations("connect time", s.connTimes)
+    fmt.Printf("published:    %d (%.1f msgs/sec)\n", s.published, float64(s.published) / secs)
+    fmt.Printf("received:     %d (%.1f msgs/sec)\n", s.received, float64(s.received) / secs)
+    printDurations("latency", s.latencies)
+    keys := make([]string, 0, len(s.errors))
+    for k := range s.errors {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        fmt.Printf("error:        %s: %d\n", k, s.errors[k])
+    }
+}
+
+// Prints min, percentiles and max of d
+func printDurations(name string, d []time.Duration) {
+    if len(d) == 0 {
+        return
+    }
+    sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+    pct := func(p float64) time.Duration {
+        return d[int(p * float64(len(d) - 1))]
+    }
+    fmt.Printf("%s: min %v, p50 %v, p90 %v, p99 %v, max %v\n", name,
+        d[0], pct(0.5), pct(0.9), pct(0.99), d[len(d) - 1])
+}
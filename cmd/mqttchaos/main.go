@@ -0,0 +1,81 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttchaos proxies MQTT traffic to a broker and injects faults,
+// see package chaos for the rule syntax.
+//
+// Usage:
+//     mqttchaos -b broker:1883 [-l :1885] [-seed n] -rule rule [-rule rule ...]
+package main
+
+import (
+    "os"
+    "fmt"
+    "log"
+    "net"
+    "flag"
+    "time"
+    "github.com/oxfeeefeee/mqttgo"
+    "github.com/oxfeeefeee/mqttgo/chaos"
+    )
+
+// Repeatable rule flag
+type ruleList []chaos.Rule
+
+func (l *ruleList) String() string {
+    return fmt.Sprint(*l)
+}
+
+func (l *ruleList) Set(s string) error {
+    r, err := chaos.ParseRule(s)
+    if err != nil {
+        return err
+    }
+    *l = append(*l, r)
+    return nil
+}
+
+func main() {
+    var rules ruleList
+    listen := flag.String("l", ":1885", "address to listen on")
+    broker := flag.String("b", "localhost:1883", "broker address")
+    seed := flag.Int64("seed", time.Now().UnixNano(), "seed of the fault sequence")
+    flag.Var(&rules, "rule", "fault rule type:action[:arg][@probability], repeatable")
+    flag.Parse()
+
+    logger := log.New(os.Stdout, "", log.LstdFlags | log.Lmicroseconds)
+    l, err := net.Listen("tcp", *listen)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "mqttchaos:", err)
+        os.Exit(1)
+    }
+    p := &chaos.Proxy{
+        Broker: *broker,
+        Seed:   *seed,
+        Rules:  rules,
+        OnFault: func(conn int, toBroker bool, r chaos.Rule, m mqttgo.Msg) {
+            dir := "<-"
+            if toBroker {
+                dir = "->"
+            }
+            logger.Printf("conn %d %s %s: %v", conn, dir, chaos.TypeName(m.MsgHeader().Type()), r)
+        },
+    }
+    logger.Printf("proxying %s to %s, seed %d, rules %v", *listen, *broker, *seed, rules)
+    if err := p.Serve(l); err != nil {
+        fmt.Fprintln(os.Stderr, "mqttchaos:", err)
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttconform runs a broker through the conformance checks and
+// prints a pass/fail report per clause, exits with 1 if any check fails.
+//
+// Usage:
+//     mqttconform [-timeout duration] host:port
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "github.com/oxfeeefeee/mqttgo/conformance"
+    )
+
+func main() {
+    timeout := flag.Duration("timeout", conformance.DefaultTimeout, "time to wait for a packet")
+    flag.Parse()
+    addr := "localhost:1883"
+    if flag.NArg() > 0 {
+        addr = flag.Arg(0)
+    }
+
+    failed := 0
+    for _, r := range conformance.Run(addr, *timeout) {
+        if r.Err != nil {
+            failed++
+            fmt.Printf("FAIL  %-14s %s: %v\n", r.Clause, r.Name, r.Err)
+        } else {
+            fmt.Printf("PASS  %-14s %s\n", r.Clause, r.Name)
+        }
+    }
+    fmt.Printf("%d/%d passed\n", len(conformance.Checks) - failed, len(conformance.Checks))
+    if failed > 0 {
+        os.Exit(1)
+    }
+}
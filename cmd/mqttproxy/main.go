@@ -0,0 +1,297 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttproxy sits between clients and a broker, decodes every
+// packet in both directions and logs it, drops or rewrites packets by
+// rules, and forwards them re-encoded or as the original bytes.
+//
+// Usage:
+//     mqttproxy -b broker:1883 [-l :1884] [-http :8080] [-raw]
+//         [-drop type[:filter] ...] [-rewrite from=to ...]
+// Rules:
+//     -drop pingreq               drops all PINGREQ
+//     -drop publish:secret/#      drops PUBLISH to topics matching secret/#
+//     -rewrite dev/=tenant/dev/   replaces the topic prefix dev/ of PUBLISH
+//                                 and SUBSCRIBE from clients, and reverses
+//                                 it for PUBLISH from the broker
+//     -rewrite =tenant/%c/        mounts the topics of each client under
+//                                 its client id, %u is the username
+// The -http address serves a live view of the connections.
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "log"
+    "net"
+    "sync"
+    "time"
+    "flag"
+    "bufio"
+    "bytes"
+    "errors"
+    "sort"
+    "strings"
+    "net/http"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+var msgNames = map[mqttgo.MsgType]string{
+    mqttgo.MsgTypeConnect:     "connect",
+    mqttgo.MsgTypeConnAck:     "connack",
+    mqttgo.MsgTypePublish:     "publish",
+    mqttgo.MsgTypePubAck:      "puback",
+    mqttgo.MsgTypePubRec:      "pubrec",
+    mqttgo.MsgTypePubRel:      "pubrel",
+    mqttgo.MsgTypePubComp:     "pubcomp",
+    mqttgo.MsgTypeSubscribe:   "subscribe",
+    mqttgo.MsgTypeSubAck:      "suback",
+    mqttgo.MsgTypeUnsubscribe: "unsubscribe",
+    mqttgo.MsgTypeUnsubAck:    "unsuback",
+    mqttgo.MsgTypePingReq:     "pingreq",
+    mqttgo.MsgTypePingResp:    "pingresp",
+    mqttgo.MsgTypeDisconnect:  "disconnect",
+}
+
+// Drops packets of a type, PUBLISH optionally only to topics matching filter
+type dropRule struct {
+    t       mqttgo.MsgType
+    filter  string
+}
+
+type dropRules []dropRule
+
+func (r *dropRules) String() string {
+    return fmt.Sprint(*r)
+}
+
+func (r *dropRules) Set(s string) error {
+    name, filter, _ := strings.Cut(s, ":")
+    for t, n := range msgNames {
+        if n == strings.ToLower(name) {
+            *r = append(*r, dropRule{t, filter})
+            return nil
+        }
+    }
+    return fmt.Errorf("unknown message type %q", name)
+}
+
+func (r dropRules) drop(m mqttgo.Msg) bool {
+    for _, d := range r {
+        if m.MsgHeader().Type() != d.t {
+            continue
+        }
+        if p, ok := m.(*mqttgo.MsgPublish); !ok || d.filter == "" || mqttgo.MatchTopic(d.filter, p.Topic) {
+            return true
+        }
+    }
+    return false
+}
+
+// Replaces topic prefixes, from clients to the broker
+type rewriteRules struct {
+    mqttgo.RewriteRules
+}
+
+func (r *rewriteRules) String() string {
+    return fmt.Sprint(r.RewriteRules)
+}
+
+func (r *rewriteRules) Set(s string) error {
+    rule, err := mqttgo.ParseRewriteRule(s)
+    if err != nil {
+        return err
+    }
+    r.RewriteRules = append(r.RewriteRules, rule)
+    return nil
+}
+
+// Live state of a proxied connection
+type session struct {
+    mu          sync.Mutex
+    addr        string
+    clientId    string
+    username    string
+    started     time.Time
+    last        time.Time
+    counts      map[string]int  // By direction and message type
+}
+
+func (s *session) count(dir string, m mqttgo.Msg) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if c, ok := m.(*mqttgo.MsgConnect); ok {
+        s.clientId, s.username = c.ClientId, c.UserName
+    }
+    s.last = time.Now()
+    s.counts[dir + " " + msgNames[m.MsgHeader().Type()]]++
+}
+
+type proxy struct {
+    broker      string
+    raw         bool
+    drops       dropRules
+    rewrites    rewriteRules
+    mu          sync.Mutex
+    sessions    map[*session]bool
+}
+
+func main() {
+    p := &proxy{sessions: make(map[*session]bool)}
+    listen := flag.String("l", ":1884", "address to listen on")
+    httpAddr := flag.String("http", "", "address to serve the live view on")
+    flag.StringVar(&p.broker, "b", "localhost:1883", "broker address")
+    flag.BoolVar(&p.raw, "raw", false, "forward the original bytes of packets not rewritten")
+    flag.Var(&p.drops, "drop", "drop rule type[:filter], repeatable")
+    flag.Var(&p.rewrites, "rewrite", "topic prefix rewrite rule from=to, %c and %u are the client id and username, repeatable")
+    flag.Parse()
+
+    l, err := net.Listen("tcp", *listen)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "mqttproxy:", err)
+        os.Exit(1)
+    }
+    if *httpAddr != "" {
+        go http.ListenAndServe(*httpAddr, p)
+    }
+    logger := log.New(os.Stdout, "", log.LstdFlags | log.Lmicroseconds)
+    logger.Printf("proxying %s to %s", *listen, p.broker)
+    for {
+        c, err := l.Accept()
+        if err != nil {
+            logger.Print(err)
+            continue
+        }
+        go p.serve(c, logger)
+    }
+}
+
+// Proxies the client connection c
+func (p *proxy) serve(c net.Conn, logger *log.Logger) {
+    defer c.Close()
+    b, err := net.Dial("tcp", p.broker)
+    if err != nil {
+        logger.Printf("%s: %v", c.RemoteAddr(), err)
+        return
+    }
+    defer b.Close()
+    s := &session{addr: c.RemoteAddr().String(), started: time.Now(), counts: make(map[string]int)}
+    p.mu.Lock()
+    p.sessions[s] = true
+    p.mu.Unlock()
+    defer func() {
+        p.mu.Lock()
+        delete(p.sessions, s)
+        p.mu.Unlock()
+    }()
+
+    done := make(chan struct{}, 2)
+    go func() { p.pipe(s, c, b, true, logger); done <- struct{}{} }()
+    go func() { p.pipe(s, b, c, false, logger); done <- struct{}{} }()
+    <-done // Closing both connections ends the other direction
+}
+
+// Decodes packets from src and forwards them to dst
+func (p *proxy) pipe(s *session, src net.Conn, dst net.Conn, toBroker bool, logger *log.Logger) {
+    defer src.Close()
+    defer dst.Close()
+    dir := "<-"
+    if toBroker {
+        dir = "->"
+    }
+    var frame bytes.Buffer
+    r := io.TeeReader(bufio.NewReader(src), &frame)
+    for {
+        frame.Reset()
+        m, err := mqttgo.Read(r)
+        if err != nil {
+            if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+                logger.Printf("%s %s decode error: %v", s.addr, dir, err)
+            }
+            return
+        }
+        s.count(dir, m)
+        if p.drops.drop(m) {
+            logger.Printf("%s %s %s (dropped)", s.addr, dir, describe(m))
+            continue
+        }
+        s.mu.Lock()
+        clientId, username := s.clientId, s.username
+        s.mu.Unlock()
+        rewritten := p.rewrites.Rewrite(m, clientId, username, !toBroker)
+        logger.Printf("%s %s %s", s.addr, dir, describe(m))
+        if p.raw && !rewritten {
+            _, err = dst.Write(frame.Bytes())
+        } else {
+            err = mqttgo.Write(dst, m)
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+// Describes m in one line
+func describe(m mqttgo.Msg) string {
+    h := m.MsgHeader()
+    qos, _ := h.Qos()
+    desc := fmt.Sprintf("%s qos=%d", msgNames[h.Type()], qos)
+    switch m := m.(type) {
+    case *mqttgo.MsgConnect:
+        desc += fmt.Sprintf(" client=%q keepalive=%d clean=%t", m.ClientId, m.KeepAlive, m.CleanSession())
+    case *mqttgo.MsgConnAck:
+        desc += fmt.Sprintf(" rc=%d", m.RC)
+    case *mqttgo.MsgPublish:
+        desc += fmt.Sprintf(" id=%d dup=%t retain=%t topic=%q len=%d",
+            m.MsgId, h.Dup(), h.Retain(), m.Topic, len(m.Content))
+    case *mqttgo.MsgSubscribe:
+        desc += fmt.Sprintf(" id=%d topics=%v", m.MsgId, m.Topics)
+    case *mqttgo.MsgSubAck:
+        desc += fmt.Sprintf(" id=%d granted=%v", m.MsgId, m.GrantedQos)
+    case *mqttgo.MsgUnsubscribe:
+        desc += fmt.Sprintf(" id=%d topics=%q", m.MsgId, m.Topics)
+    case mqttgo.MsgWithId:
+        desc += fmt.Sprintf(" id=%d", m.Id())
+    }
+    return desc
+}
+
+// Serves the live view of the connections
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    p.mu.Lock()
+    sessions := make([]*session, 0, len(p.sessions))
+    for s := range p.sessions {
+        sessions = append(sessions, s)
+    }
+    p.mu.Unlock()
+    sort.Slice(sessions, func(i, j int) bool { return sessions[i].started.Before(sessions[j].started) })
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    fmt.Fprintf(w, "%d connections to %s\n", len(sessions), p.broker)
+    for _, s := range sessions {
+        s.mu.Lock()
+        fmt.Fprintf(w, "\n%s client=%q up %v, last packet %v ago\n", s.addr, s.clientId,
+            time.Since(s.started).Round(time.Second), time.Since(s.last).Round(time.Millisecond))
+        keys := make([]string, 0, len(s.counts))
+        for k := range s.counts {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        for _, k := range keys {
+            fmt.Fprintf(w, "    %-16s %d\n", k, s.counts[k])
+        }
+        s.mu.Unlock()
+    }
+}
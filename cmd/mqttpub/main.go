@@ -0,0 +1,109 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttpub publishes a single message, like mosquitto_pub.
+//
+// Usage:
+//     mqttpub -t topic [-m message | -f file] [-q qos] [-r] [flags]
+// Use "-f -" to read the message from stdin.
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "github.com/oxfeeefeee/mqttgo"
+    "github.com/oxfeeefeee/mqttgo/cmd/internal/mqttcli"
+    )
+
+func main() {
+    var opts mqttcli.Options
+    opts.Register(flag.CommandLine)
+    topic := flag.String("t", "", "topic to publish to")
+    message := flag.String("m", "", "message to publish")
+    file := flag.String("f", "", "publish the content of file, - for stdin")
+    qos := flag.Int("q", 0, "QoS level")
+    retain := flag.Bool("r", false, "retain the message")
+    flag.Parse()
+
+    if *topic == "" {
+        fail(fmt.Errorf("-t is required"))
+    }
+    content := []byte(*message)
+    if *file == "-" {
+        content = readAll(io.ReadAll(os.Stdin))
+    } else if *file != "" {
+        content = readAll(os.ReadFile(*file))
+    }
+    if !mqttgo.QosLevel(*qos).Valid() {
+        fail(mqttgo.ErrBadQosLevel)
+    }
+    c, err := opts.Connect()
+    if err != nil {
+        fail(err)
+    }
+    defer c.Close()
+    if err := publish(c, *topic, mqttgo.QosLevel(*qos), *retain, content); err != nil {
+        fail(err)
+    }
+}
+
+// Publishes the message and waits for the QoS flow to complete
+func publish(c *mqttcli.Conn, topic string, qos mqttgo.QosLevel, retain bool, content []byte) error {
+    m := mqttgo.NewPub(topic, qos, content)
+    m.H.SetRetain(retain)
+    if qos == mqttgo.QosAtMostOnce {
+        return c.Write(m)
+    }
+    m.MsgId = c.NextId()
+    if err := c.Write(m); err != nil {
+        return err
+    }
+    for {
+        r, err := c.Read()
+        if err != nil {
+            return err
+        }
+        switch r := r.(type) {
+        case *mqttgo.MsgPubAck:
+            if r.MsgId == m.MsgId {
+                return nil
+            }
+        case *mqttgo.MsgPubRec:
+            if r.MsgId == m.MsgId {
+                if err := c.Write(mqttgo.NewPubRel(m.MsgId)); err != nil {
+                    return err
+                }
+            }
+        case *mqttgo.MsgPubComp:
+            if r.MsgId == m.MsgId {
+                return nil
+            }
+        }
+    }
+}
+
+func readAll(p []byte, err error) []byte {
+    if err != nil {
+        fail(err)
+    }
+    return p
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, "mqttpub:", err)
+    os.Exit(1)
+}
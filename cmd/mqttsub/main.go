@@ -0,0 +1,133 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Command mqttsub subscribes to topics and prints the messages received,
+// like mosquitto_sub.
+//
+// Usage:
+//     mqttsub -t filter [-t filter ...] [-q qos] [-v | -json] [-C count] [flags]
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "strings"
+    "unicode/utf8"
+    "encoding/json"
+    "encoding/base64"
+    "github.com/oxfeeefeee/mqttgo"
+    "github.com/oxfeeefeee/mqttgo/cmd/internal/mqttcli"
+    )
+
+// Repeatable string flag
+type topicList []string
+
+func (l *topicList) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *topicList) Set(s string) error {
+    *l = append(*l, s)
+    return nil
+}
+
+// One line of -json output
+type jsonLine struct {
+    Topic       string  `json:"topic"`
+    Qos         int     `json:"qos"`
+    Retain      bool    `json:"retain"`
+    Payload     string  `json:"payload"`
+    Encoding    string  `json:"encoding,omitempty"`    // "base64" if the payload isn't UTF-8
+}
+
+func main() {
+    var opts mqttcli.Options
+    var topics topicList
+    opts.Register(flag.CommandLine)
+    flag.Var(&topics, "t", "topic filter to subscribe to, repeatable")
+    qos := flag.Int("q", 0, "QoS level")
+    verbose := flag.Bool("v", false, "print the topic before the payload")
+    jsonOut := flag.Bool("json", false, "print each message as a JSON line")
+    count := flag.Int("C", 0, "exit after receiving count messages")
+    flag.Parse()
+
+    if len(topics) == 0 {
+        fail(fmt.Errorf("-t is required"))
+    }
+    if !mqttgo.QosLevel(*qos).Valid() {
+        fail(mqttgo.ErrBadQosLevel)
+    }
+    c, err := opts.Connect()
+    if err != nil {
+        fail(err)
+    }
+    defer c.Close()
+    if err := c.Write(mqttgo.NewSubscribe(c.NextId(), mqttgo.QosLevel(*qos), topics...)); err != nil {
+        fail(err)
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+    enc := json.NewEncoder(out)
+    for n := 0; *count <= 0 || n < *count; {
+        m, err := c.Read()
+        if err != nil {
+            out.Flush()
+            fail(err)
+        }
+        switch m := m.(type) {
+        case *mqttgo.MsgSubAck:
+            for _, q := range m.GrantedQos {
+                if !q.Valid() {
+                    out.Flush()
+                    fail(fmt.Errorf("subscription refused"))
+                }
+            }
+        case *mqttgo.MsgPubRel:
+            err = c.Write(mqttgo.NewPubComp(m.MsgId))
+        case *mqttgo.MsgPublish:
+            q, _ := m.H.Qos()
+            if q == mqttgo.QosAtLeastOnce {
+                err = c.Write(mqttgo.NewPubAck(m.MsgId))
+            } else if q == mqttgo.QosExactlyOnce {
+                err = c.Write(mqttgo.NewPubRec(m.MsgId))
+            }
+            if *jsonOut {
+                line := jsonLine{m.Topic, int(q), m.H.Retain(), string(m.Content), ""}
+                if !utf8.Valid(m.Content) {
+                    line.Payload = base64.StdEncoding.EncodeToString(m.Content)
+                    line.Encoding = "base64"
+                }
+                enc.Encode(line)
+            } else if *verbose {
+                fmt.Fprintf(out, "%s %s\n", m.Topic, m.Content)
+            } else {
+                fmt.Fprintf(out, "%s\n", m.Content)
+            }
+            out.Flush()
+            n++
+        }
+        if err != nil {
+            fail(err)
+        }
+    }
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, "mqttsub:", err)
+    os.Exit(1)
+}
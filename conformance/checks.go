@@ -0,0 +1,265 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements the checks, one function per normative statement
+package conformance
+
+import (
+    "fmt"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Publishes m and completes its QoS flow
+func (c *Conn) Publish(m *mqttgo.MsgPublish) error {
+    if err := c.Send(m); err != nil {
+        return err
+    }
+    switch qos, _ := m.H.Qos(); qos {
+    case mqttgo.QosAtLeastOnce:
+        _, err := c.Expect(mqttgo.MsgTypePubAck)
+        return err
+    case mqttgo.QosExactlyOnce:
+        if _, err := c.Expect(mqttgo.MsgTypePubRec); err != nil {
+            return err
+        } else if err := c.Send(mqttgo.NewPubRel(m.MsgId)); err != nil {
+            return err
+        }
+        _, err := c.Expect(mqttgo.MsgTypePubComp)
+        return err
+    }
+    return nil
+}
+
+// Checks p was published to topic with content
+func expectPub(p *mqttgo.MsgPublish, topic string, content string) error {
+    if p.Topic != topic {
+        return fmt.Errorf("PUBLISH to %s, expected %s", p.Topic, topic)
+    } else if string(p.Content) != content {
+        return fmt.Errorf("PUBLISH content %q, expected %q", p.Content, content)
+    }
+    return nil
+}
+
+func checkFirstConnect(t *Target) error {
+    c, err := t.Dial()
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if err := c.Send(mqttgo.NewPingReq()); err != nil {
+        return err
+    }
+    return c.ExpectClosed()
+}
+
+func checkSecondConnect(t *Target) error {
+    c, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if err := c.Send(mqttgo.NewConnect(t.ClientId(), 0)); err != nil {
+        return err
+    }
+    return c.ExpectClosed()
+}
+
+func checkDowngrade(t *Target) error {
+    topic := t.Topic("downgrade")
+    pub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer pub.Close()
+    requested := []mqttgo.QosLevel{mqttgo.QosAtMostOnce, mqttgo.QosAtLeastOnce}
+    subs := make([]*Conn, len(requested))
+    granted := make([]mqttgo.QosLevel, len(requested))
+    for i, qos := range requested {
+        if subs[i], err = t.ConnectClean(); err != nil {
+            return err
+        }
+        defer subs[i].Close()
+        if granted[i], err = subs[i].Subscribe(1, topic, qos); err != nil {
+            return err
+        } else if granted[i] > qos {
+            return fmt.Errorf("requested QoS %d, granted QoS %d", qos, granted[i])
+        }
+    }
+    m := mqttgo.NewPub(topic, mqttgo.QosExactlyOnce, []byte("downgrade"))
+    m.MsgId = 1
+    if err := pub.Publish(m); err != nil {
+        return err
+    }
+    for i, sub := range subs {
+        p, err := sub.RecvPublish()
+        if err != nil {
+            return err
+        } else if qos, _ := p.H.Qos(); qos != granted[i] {
+            // Published with QoS 2, so the minimum is the granted QoS
+            return fmt.Errorf("published with QoS 2, granted QoS %d, delivered with QoS %d",
+                granted[i], qos)
+        } else if err := expectPub(p, topic, "downgrade"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func checkRetained(t *Target) error {
+    topic := t.Topic("retained")
+    pub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer pub.Close()
+    m := mqttgo.NewPub(topic, mqttgo.QosAtLeastOnce, []byte("retained"))
+    m.MsgId = 1
+    m.H.SetRetain(true)
+    if err := pub.Publish(m); err != nil {
+        return err
+    }
+    defer func() { // Clear the retained message
+        empty := mqttgo.NewPub(topic, mqttgo.QosAtMostOnce, nil)
+        empty.H.SetRetain(true)
+        pub.Publish(empty)
+    }()
+    sub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer sub.Close()
+    if _, err := sub.Subscribe(1, topic, mqttgo.QosAtLeastOnce); err != nil {
+        return err
+    }
+    p, err := sub.RecvPublish()
+    if err != nil {
+        return err
+    } else if !p.H.Retain() {
+        return fmt.Errorf("retained message delivered without RETAIN flag")
+    }
+    return expectPub(p, topic, "retained")
+}
+
+func checkWill(t *Target) error {
+    topic := t.Topic("will")
+    sub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer sub.Close()
+    if _, err := sub.Subscribe(1, topic, mqttgo.QosAtLeastOnce); err != nil {
+        return err
+    }
+    m := mqttgo.NewConnect(t.ClientId(), 0)
+    m.SetCleanSession(true)
+    m.SetWillFlag(true)
+    m.SetWillQos(mqttgo.QosAtLeastOnce)
+    m.WillTopic = topic
+    m.WillMsg = "will"
+    c, err := t.Connect(m)
+    if err != nil {
+        return err
+    }
+    c.Close() // Without DISCONNECT
+    p, err := sub.RecvPublish()
+    if err != nil {
+        return err
+    }
+    return expectPub(p, topic, "will")
+}
+
+func checkQos2Dup(t *Target) error {
+    topic := t.Topic("qos2dup")
+    sub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer sub.Close()
+    if _, err := sub.Subscribe(1, topic, mqttgo.QosExactlyOnce); err != nil {
+        return err
+    }
+    pub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer pub.Close()
+    m := mqttgo.NewPub(topic, mqttgo.QosExactlyOnce, []byte("qos2"))
+    m.MsgId = 7
+    if err := pub.Send(m); err != nil {
+        return err
+    } else if _, err := pub.Expect(mqttgo.MsgTypePubRec); err != nil {
+        return err
+    }
+    m.H.SetDup(true) // Resend before PUBREL, as if PUBREC was lost
+    if err := pub.Send(m); err != nil {
+        return err
+    } else if _, err := pub.Expect(mqttgo.MsgTypePubRec); err != nil {
+        return err
+    } else if err := pub.Send(mqttgo.NewPubRel(m.MsgId)); err != nil {
+        return err
+    } else if _, err := pub.Expect(mqttgo.MsgTypePubComp); err != nil {
+        return err
+    }
+    p, err := sub.RecvPublish()
+    if err != nil {
+        return err
+    } else if err := expectPub(p, topic, "qos2"); err != nil {
+        return err
+    }
+    return sub.ExpectNoPublish()
+}
+
+func checkSession(t *Target) error {
+    topic := t.Topic("session")
+    m := mqttgo.NewConnect(t.ClientId(), 0)
+    m.SetCleanSession(false)
+    c, err := t.Connect(m)
+    if err != nil {
+        return err
+    }
+    _, err = c.Subscribe(1, topic, mqttgo.QosAtLeastOnce)
+    c.Send(mqttgo.NewDisconnect())
+    c.Close()
+    if err != nil {
+        return err
+    }
+    defer func() { // Discard the session
+        m.SetCleanSession(true)
+        if c, err := t.Connect(m); err == nil {
+            c.Send(mqttgo.NewDisconnect())
+            c.Close()
+        }
+    }()
+
+    pub, err := t.ConnectClean()
+    if err != nil {
+        return err
+    }
+    defer pub.Close()
+    msg := mqttgo.NewPub(topic, mqttgo.QosAtLeastOnce, []byte("session"))
+    msg.MsgId = 1
+    if err := pub.Publish(msg); err != nil {
+        return err
+    }
+    if c, err = t.Connect(m); err != nil {
+        return err
+    }
+    defer c.Close()
+    p, err := c.RecvPublish()
+    if err != nil {
+        return err
+    }
+    return expectPub(p, topic, "session")
+}
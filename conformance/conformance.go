@@ -0,0 +1,261 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package conformance runs a broker under test through normative
+// statements of MQTT 3.1.1, driving raw packets built with mqttgo.
+//
+// Use Run for a report, or RunT from a Go test:
+//     func TestBroker(t *testing.T) {
+//         conformance.RunT(t, "localhost:1883")
+//     }
+package conformance
+
+import (
+    "fmt"
+    "net"
+    "time"
+    "bufio"
+    "errors"
+    "testing"
+    "strconv"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Default time to wait for a packet from the broker
+const DefaultTimeout = 2 * time.Second
+
+// A normative statement and how to check it
+type Check struct {
+    Clause  string  // e.g. "MQTT-3.1.0-1"
+    Name    string
+    Run     func(t *Target) error
+}
+
+// The result of a Check
+type Result struct {
+    Check
+    Err     error   // nil if passed
+}
+
+// The broker under test
+type Target struct {
+    Addr    string
+    Timeout time.Duration
+    prefix  string  // Unique topic prefix of the run
+    nextId  int
+}
+
+// All checks, in the order they are run
+var Checks = []Check{
+    {"MQTT-3.1.0-1", "first packet must be CONNECT", checkFirstConnect},
+    {"MQTT-3.1.0-2", "second CONNECT disconnects", checkSecondConnect},
+    {"MQTT-3.8.4-6", "delivered QoS is the minimum of published and granted", checkDowngrade},
+    {"MQTT-3.3.1-6", "retained message is delivered to new subscriptions", checkRetained},
+    {"MQTT-3.1.2-8", "will is published when the connection is lost", checkWill},
+    {"MQTT-4.3.3-2", "QoS 2 duplicates are not delivered twice", checkQos2Dup},
+    {"MQTT-3.1.2-4", "session is resumed when CleanSession is 0", checkSession},
+}
+
+// Runs all checks against the broker at addr
+func Run(addr string, timeout time.Duration) []Result {
+    if timeout <= 0 {
+        timeout = DefaultTimeout
+    }
+    t := &Target{
+        Addr:       addr,
+        Timeout:    timeout,
+        prefix:     "mqttconform/" + strconv.FormatInt(time.Now().UnixNano(), 36),
+    }
+    results := make([]Result, len(Checks))
+    for i, c := range Checks {
+        results[i] = Result{c, c.Run(t)}
+    }
+    return results
+}
+
+// Runs all checks, reporting the failed ones as errors of t
+func RunT(t testing.TB, addr string) {
+    t.Helper()
+    for _, r := range Run(addr, DefaultTimeout) {
+        if r.Err != nil {
+            t.Errorf("%s %s: %v", r.Clause, r.Name, r.Err)
+        }
+    }
+}
+
+// Returns a topic unique to this run
+func (t *Target) Topic(name string) string {
+    return t.prefix + "/" + name
+}
+
+// Returns a client id unique to this run
+func (t *Target) ClientId() string {
+    t.nextId++
+    return fmt.Sprintf("conform-%s-%d", t.prefix[len("mqttconform/"):], t.nextId)
+}
+
+// A raw connection to the broker
+type Conn struct {
+    net.Conn
+    r       *bufio.Reader
+    timeout time.Duration
+    pending []*mqttgo.MsgPublish    // Received while expecting other packets
+}
+
+// Dials the broker without sending anything
+func (t *Target) Dial() (*Conn, error) {
+    c, err := net.DialTimeout("tcp", t.Addr, t.Timeout)
+    if err != nil {
+        return nil, err
+    }
+    return &Conn{Conn: c, r: bufio.NewReader(c), timeout: t.Timeout}, nil
+}
+
+// Dials and connects, m is sent as the CONNECT, CONNACK must accept it
+func (t *Target) Connect(m *mqttgo.MsgConnect) (*Conn, error) {
+    c, err := t.Dial()
+    if err != nil {
+        return nil, err
+    }
+    if err := c.Send(m); err != nil {
+        c.Close()
+        return nil, err
+    }
+    if ack, err := c.Expect(mqttgo.MsgTypeConnAck); err != nil {
+        c.Close()
+        return nil, err
+    } else if rc := ack.(*mqttgo.MsgConnAck).RC; rc != mqttgo.RCAccepted {
+        c.Close()
+        return nil, fmt.Errorf("CONNACK return code %d", rc)
+    }
+    return c, nil
+}
+
+// Connects with a new clean session
+func (t *Target) ConnectClean() (*Conn, error) {
+    m := mqttgo.NewConnect(t.ClientId(), 0)
+    m.SetCleanSession(true)
+    return t.Connect(m)
+}
+
+// Sends m
+func (c *Conn) Send(m mqttgo.Msg) error {
+    c.SetWriteDeadline(time.Now().Add(c.timeout))
+    return mqttgo.Write(c.Conn, m)
+}
+
+// Receives the next packet
+func (c *Conn) Recv() (mqttgo.Msg, error) {
+    if len(c.pending) > 0 {
+        p := c.pending[0]
+        c.pending = c.pending[1:]
+        return p, nil
+    }
+    return c.read()
+}
+
+func (c *Conn) read() (mqttgo.Msg, error) {
+    c.SetReadDeadline(time.Now().Add(c.timeout))
+    return mqttgo.Read(c.r)
+}
+
+// Receives packets until one of type t, which is returned.
+// PUBLISH received meanwhile are kept for Recv and Expect, so that
+// duplicates can't go unnoticed, other packets are skipped.
+func (c *Conn) Expect(t mqttgo.MsgType) (mqttgo.Msg, error) {
+    if t == mqttgo.MsgTypePublish && len(c.pending) > 0 {
+        return c.Recv()
+    }
+    for {
+        m, err := c.read()
+        if err != nil {
+            return nil, fmt.Errorf("waiting for message type %d: %v", t, err)
+        }
+        if m.MsgHeader().Type() == t {
+            return m, nil
+        } else if p, ok := m.(*mqttgo.MsgPublish); ok {
+            c.pending = append(c.pending, p)
+        }
+    }
+}
+
+// Checks the broker closes the connection without sending anything else
+func (c *Conn) ExpectClosed() error {
+    m, err := c.Recv()
+    if err == nil {
+        return fmt.Errorf("expected the connection closed, got message type %d",
+            m.MsgHeader().Type())
+    }
+    var ne net.Error
+    if errors.As(err, &ne) && ne.Timeout() {
+        return errors.New("expected the connection closed, it is still open")
+    }
+    return nil // EOF, connection reset, etc.
+}
+
+// Checks no PUBLISH arrives within the timeout
+func (c *Conn) ExpectNoPublish() error {
+    for {
+        m, err := c.Recv()
+        var ne net.Error
+        if errors.As(err, &ne) && ne.Timeout() {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        if p, ok := m.(*mqttgo.MsgPublish); ok {
+            return fmt.Errorf("unexpected PUBLISH to %s", p.Topic)
+        }
+    }
+}
+
+// Subscribes to filter and waits for SUBACK, returns the granted QoS
+func (c *Conn) Subscribe(id uint16, filter string, qos mqttgo.QosLevel) (mqttgo.QosLevel, error) {
+    if err := c.Send(mqttgo.NewSubscribe(id, qos, filter)); err != nil {
+        return 0, err
+    }
+    m, err := c.Expect(mqttgo.MsgTypeSubAck)
+    if err != nil {
+        return 0, err
+    }
+    ack := m.(*mqttgo.MsgSubAck)
+    if ack.MsgId != id {
+        return 0, fmt.Errorf("SUBACK id %d, expected %d", ack.MsgId, id)
+    } else if len(ack.GrantedQos) != 1 {
+        return 0, fmt.Errorf("SUBACK has %d return codes, expected 1", len(ack.GrantedQos))
+    } else if !ack.GrantedQos[0].Valid() {
+        return 0, fmt.Errorf("subscription refused, return code %#x", ack.GrantedQos[0])
+    }
+    return ack.GrantedQos[0], nil
+}
+
+// Receives a PUBLISH, acknowledging it as its QoS level requires
+func (c *Conn) RecvPublish() (*mqttgo.MsgPublish, error) {
+    m, err := c.Expect(mqttgo.MsgTypePublish)
+    if err != nil {
+        return nil, err
+    }
+    p := m.(*mqttgo.MsgPublish)
+    if qos, _ := p.H.Qos(); qos == mqttgo.QosAtLeastOnce {
+        err = c.Send(mqttgo.NewPubAck(p.MsgId))
+    } else if qos == mqttgo.QosExactlyOnce {
+        if err = c.Send(mqttgo.NewPubRec(p.MsgId)); err == nil {
+            if _, err = c.Expect(mqttgo.MsgTypePubRel); err == nil {
+                err = c.Send(mqttgo.NewPubComp(p.MsgId))
+            }
+        }
+    }
+    return p, err
+}
@@ -0,0 +1,283 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package conformance
+
+import (
+    "net"
+    "sync"
+    "time"
+    "bufio"
+    "testing"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Ways the stub broker breaks a clause, all false for a conforming broker
+type stubFaults struct {
+    noFirstConnect  bool    // MQTT-3.1.0-1, ignores packets before CONNECT
+    noSecondConnect bool    // MQTT-3.1.0-2, ignores a second CONNECT
+    noDowngrade     bool    // MQTT-3.8.4-6, delivers with the published QoS
+    noRetain        bool    // MQTT-3.3.1-6, keeps no retained messages
+    noWill          bool    // MQTT-3.1.2-8, never publishes wills
+    qos2Twice       bool    // MQTT-4.3.3-2, delivers duplicate QoS 2 PUBLISH
+    noSession       bool    // MQTT-3.1.2-4, always starts a clean session
+}
+
+// A minimal in-memory broker, just enough for the checks
+type stubBroker struct {
+    stubFaults
+    l           net.Listener
+    mu          sync.Mutex
+    retained    map[string]*mqttgo.MsgPublish
+    sessions    map[string]*stubSession     // By client id
+}
+
+type stubSession struct {
+    subs    map[string]mqttgo.QosLevel
+    conn    *stubConn                       // nil while disconnected
+    queue   []*mqttgo.MsgPublish            // Delivered when connected again
+}
+
+type stubConn struct {
+    net.Conn
+    wmu     sync.Mutex
+    nextId  uint16
+}
+
+func startStub(t *testing.T, f stubFaults) string {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+    b := &stubBroker{
+        stubFaults: f,
+        l:          l,
+        retained:   make(map[string]*mqttgo.MsgPublish),
+        sessions:   make(map[string]*stubSession),
+    }
+    go func() {
+        for {
+            c, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go b.serve(&stubConn{Conn: c})
+        }
+    }()
+    return l.Addr().String()
+}
+
+func (c *stubConn) send(m mqttgo.Msg) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    if p, ok := m.(*mqttgo.MsgPublish); ok {
+        if qos, _ := p.H.Qos(); qos > mqttgo.QosAtMostOnce {
+            c.nextId++
+            p.MsgId = c.nextId
+        }
+    }
+    mqttgo.Write(c, m)
+}
+
+func (b *stubBroker) serve(c *stubConn) {
+    defer c.Close()
+    r := bufio.NewReader(c)
+    var s *stubSession
+    var clientId string
+    var clean bool
+    var will *mqttgo.MsgPublish
+    received := make(map[uint16]bool) // QoS 2 ids waiting for PUBREL
+    defer func() {
+        if s == nil {
+            return
+        }
+        b.mu.Lock()
+        if s.conn == c {
+            s.conn = nil
+        }
+        if clean && b.sessions[clientId] == s {
+            delete(b.sessions, clientId)
+        }
+        b.mu.Unlock()
+        if will != nil && !b.noWill {
+            b.route(will)
+        }
+    }()
+    for {
+        m, err := mqttgo.Read(r)
+        if err != nil {
+            return
+        }
+        if s == nil {
+            cm, ok := m.(*mqttgo.MsgConnect)
+            if !ok {
+                if b.noFirstConnect {
+                    continue
+                }
+                return
+            }
+            clientId, clean = cm.ClientId, cm.CleanSession() || b.noSession
+            if cm.WillFlag() {
+                qos, _ := cm.WillQos()
+                will = mqttgo.NewPub(cm.WillTopic, qos, []byte(cm.WillMsg))
+                will.H.SetRetain(cm.WillRetain())
+            }
+            b.mu.Lock()
+            if s = b.sessions[clientId]; s == nil || clean {
+                s = &stubSession{subs: make(map[string]mqttgo.QosLevel)}
+                b.sessions[clientId] = s
+            }
+            s.conn = c
+            queue := s.queue
+            s.queue = nil
+            b.mu.Unlock()
+            c.send(mqttgo.NewConnAck(mqttgo.RCAccepted))
+            for _, p := range queue {
+                c.send(p)
+            }
+            continue
+        }
+        switch m := m.(type) {
+        case *mqttgo.MsgConnect:
+            if !b.noSecondConnect {
+                return
+            }
+        case *mqttgo.MsgSubscribe:
+            ack := &mqttgo.MsgSubAck{MsgId: m.MsgId}
+            ack.H.SetType(mqttgo.MsgTypeSubAck)
+            b.mu.Lock()
+            for _, t := range m.Topics {
+                s.subs[t.Topic] = t.QosLevel
+                ack.GrantedQos = append(ack.GrantedQos, t.QosLevel)
+            }
+            var retained []*mqttgo.MsgPublish
+            for topic, p := range b.retained {
+                for _, t := range m.Topics {
+                    if mqttgo.MatchTopic(t.Topic, topic) {
+                        d := b.delivery(p, t.QosLevel)
+                        d.H.SetRetain(true)
+                        retained = append(retained, d)
+                        break
+                    }
+                }
+            }
+            b.mu.Unlock()
+            c.send(ack)
+            for _, p := range retained {
+                c.send(p)
+            }
+        case *mqttgo.MsgPublish:
+            qos, _ := m.H.Qos()
+            if qos == mqttgo.QosExactlyOnce {
+                dup := received[m.MsgId]
+                received[m.MsgId] = true
+                c.send(mqttgo.NewPubRec(m.MsgId))
+                if dup && !b.qos2Twice {
+                    continue
+                }
+            } else if qos == mqttgo.QosAtLeastOnce {
+                c.send(mqttgo.NewPubAck(m.MsgId))
+            }
+            if m.H.Retain() && !b.noRetain {
+                b.mu.Lock()
+                if len(m.Content) == 0 {
+                    delete(b.retained, m.Topic)
+                } else {
+                    b.retained[m.Topic] = m
+                }
+                b.mu.Unlock()
+            }
+            b.route(m)
+        case *mqttgo.MsgPubRel:
+            delete(received, m.MsgId)
+            c.send(mqttgo.NewPubComp(m.MsgId))
+        case *mqttgo.MsgPubRec:
+            c.send(mqttgo.NewPubRel(m.MsgId))
+        case *mqttgo.MsgDisconnect:
+            will = nil
+            return
+        }
+    }
+}
+
+// Returns a copy of p to deliver to a subscription granted qos,
+// b.mu must be held
+func (b *stubBroker) delivery(p *mqttgo.MsgPublish, granted mqttgo.QosLevel) *mqttgo.MsgPublish {
+    qos, _ := p.H.Qos()
+    if granted < qos && !b.noDowngrade {
+        qos = granted
+    }
+    return mqttgo.NewPub(p.Topic, qos, p.Content)
+}
+
+// Delivers p to every session with a matching subscription
+func (b *stubBroker) route(p *mqttgo.MsgPublish) {
+    type delivery struct {
+        c   *stubConn
+        p   *mqttgo.MsgPublish
+    }
+    var ds []delivery
+    b.mu.Lock()
+    for _, s := range b.sessions {
+        for filter, granted := range s.subs {
+            if !mqttgo.MatchTopic(filter, p.Topic) {
+                continue
+            }
+            d := b.delivery(p, granted)
+            if s.conn != nil {
+                ds = append(ds, delivery{s.conn, d})
+            } else if qos, _ := d.H.Qos(); qos > mqttgo.QosAtMostOnce {
+                s.queue = append(s.queue, d)
+            }
+            break
+        }
+    }
+    b.mu.Unlock()
+    for _, d := range ds {
+        d.c.send(d.p)
+    }
+}
+
+func TestStubPasses(t *testing.T) {
+    RunT(t, startStub(t, stubFaults{}))
+}
+
+func TestStubFails(t *testing.T) {
+    faults := map[string]stubFaults{
+        "MQTT-3.1.0-1": {noFirstConnect: true},
+        "MQTT-3.1.0-2": {noSecondConnect: true},
+        "MQTT-3.8.4-6": {noDowngrade: true},
+        "MQTT-3.3.1-6": {noRetain: true},
+        "MQTT-3.1.2-8": {noWill: true},
+        "MQTT-4.3.3-2": {qos2Twice: true},
+        "MQTT-3.1.2-4": {noSession: true},
+    }
+    for _, c := range Checks {
+        f, ok := faults[c.Clause]
+        if !ok {
+            t.Errorf("%s: no failing case", c.Clause)
+            continue
+        }
+        target := &Target{
+            Addr:       startStub(t, f),
+            Timeout:    300 * time.Millisecond,
+            prefix:     "mqttconform/test",
+        }
+        if err := c.Run(target); err == nil {
+            t.Errorf("%s passed against a broker breaking it", c.Clause)
+        }
+    }
+}
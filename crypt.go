@@ -0,0 +1,156 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements end-to-end encryption of MsgPublish.Content
+// with AES-GCM, the broker only sees the envelope.
+//
+// Envelope format:
+// |------------------------------------------------------------------|
+// | byte1          | Version                                         |
+// | byte2          | Algorithm                                       |
+// | byte3          | Length of key id                                |
+// | byte4+         | Key id                                          |
+// |------------------------------------------------------------------|
+// |                Nonce, 12 bytes                                   |
+// |------------------------------------------------------------------|
+// |                Ciphertext and tag                                |
+// |------------------------------------------------------------------|
+// The envelope header (up to the nonce) and the topic are authenticated
+// as associated data, so a payload can't be replayed onto another topic.
+package mqttgo
+
+import (
+    "sync"
+    "errors"
+    "crypto/aes"
+    "crypto/rand"
+    "crypto/cipher"
+    )
+
+const (
+    EnvelopeVersion = 1
+    AlgAESGCM       = 1
+    )
+
+// Errors could happen when sealing/opening payloads
+var (
+    ErrBadEnvelope = errors.New("mqttgo/crypt: Bad payload envelope")
+    ErrUnknownKey = errors.New("mqttgo/crypt: Unknown key id")
+    ErrBadKeyId = errors.New("mqttgo/crypt: Key id must be 1 to 255 bytes")
+    )
+
+// Encrypts/decrypts MsgPublish.Content, keys are kept by id so that
+// payloads sealed with a rotated out key can still be opened.
+type PayloadCipher struct {
+    mu      sync.RWMutex
+    keys    map[string]cipher.AEAD
+    current string
+}
+
+// Creates a PayloadCipher sealing with key, which must be 16, 24 or 32 bytes
+func NewPayloadCipher(keyId string, key []byte) (*PayloadCipher, error) {
+    c := &PayloadCipher{keys: make(map[string]cipher.AEAD)}
+    if err := c.AddKey(keyId, key); err != nil {
+        return nil, err
+    }
+    c.current = keyId
+    return c, nil
+}
+
+// Adds a key for opening payloads, use SetCurrent to seal with it
+func (c *PayloadCipher) AddKey(keyId string, key []byte) error {
+    if len(keyId) == 0 || len(keyId) > 0xff {
+        return ErrBadKeyId
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return err
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.keys[keyId] = aead
+    return nil
+}
+
+// Removes a key, payloads sealed with it can no longer be opened
+func (c *PayloadCipher) RemoveKey(keyId string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if keyId != c.current {
+        delete(c.keys, keyId)
+    }
+}
+
+// Sets the key used for sealing
+func (c *PayloadCipher) SetCurrent(keyId string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.keys[keyId]; !ok {
+        return ErrUnknownKey
+    }
+    c.current = keyId
+    return nil
+}
+
+// Replaces m.Content with the sealed envelope
+func (c *PayloadCipher) Seal(m *MsgPublish) error {
+    c.mu.RLock()
+    keyId, aead := c.current, c.keys[c.current]
+    c.mu.RUnlock()
+    h := make([]byte, 0, 3 + len(keyId) + aead.NonceSize())
+    h = append(h, EnvelopeVersion, AlgAESGCM, byte(len(keyId)))
+    h = append(h, keyId...)
+    hlen := len(h)
+    h = h[:hlen + aead.NonceSize()]
+    if _, err := rand.Read(h[hlen:]); err != nil {
+        return err
+    }
+    ad := append(h[:hlen:hlen], m.Topic...)
+    m.Content = aead.Seal(h, h[hlen:], m.Content, ad)
+    return nil
+}
+
+// Replaces the sealed envelope in m.Content with the plain content
+func (c *PayloadCipher) Open(m *MsgPublish) error {
+    p := m.Content
+    if len(p) < 3 || p[0] != EnvelopeVersion || p[1] != AlgAESGCM {
+        return ErrBadEnvelope
+    }
+    hlen := 3 + int(p[2])
+    if len(p) < hlen {
+        return ErrBadEnvelope
+    }
+    c.mu.RLock()
+    aead, ok := c.keys[string(p[3:hlen])]
+    c.mu.RUnlock()
+    if !ok {
+        return ErrUnknownKey
+    }
+    if len(p) < hlen + aead.NonceSize() + aead.Overhead() {
+        return ErrBadEnvelope
+    }
+    ad := append(append([]byte(nil), p[:hlen]...), m.Topic...)
+    nonce := p[hlen:hlen + aead.NonceSize()]
+    plain, err := aead.Open(nil, nonce, p[hlen + aead.NonceSize():], ad)
+    if err != nil {
+        return err
+    }
+    m.Content = plain
+    return nil
+}
@@ -166,9 +166,9 @@ func get1Bit(f byte, mask byte) bool {
 
 // Sets one bit in a byte
 func set1Bit(f *byte, v bool, mask byte) {
-    *f = *f &^ 0x08
+    *f = *f &^ mask
     if v {
-        *f = *f | 0x08  
+        *f = *f | mask
     }
 }
 
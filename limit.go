@@ -0,0 +1,196 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements rate limits a broker applies to the clients of a
+// listener, keyed by MsgConnect.ClientId:
+// - publishes/sec and bytes/sec, per client and per listener, as token
+//   buckets; bytes are the remaining length of each MsgPublish
+// - the number of subscriptions per client
+// A client exceeding a rate is either throttled, i.e. the broker stops
+// reading from its socket for the returned duration, or disconnected.
+package mqttgo
+
+import (
+    "sync"
+    "time"
+    "errors"
+    )
+
+type LimitPolicy int
+
+const (
+    LimitThrottle LimitPolicy = iota
+    LimitDisconnect
+    )
+
+var (
+    ErrRateLimited  = errors.New("mqttgo/limit: Rate limit exceeded")
+    ErrTooManySubs  = errors.New("mqttgo/limit: Too many subscriptions")
+    )
+
+// Rate of a token bucket
+type Rate struct {
+    PerSec  float64             // Zero for no limit
+    Burst   float64             // Size of the bucket, PerSec if zero
+}
+
+// Limits of a listener, zero values for no limit
+type LimitConfig struct {
+    ClientPubs      Rate        // Publishes/sec of each client
+    ClientBytes     Rate        // Bytes/sec of each client
+    ListenerPubs    Rate        // Publishes/sec of all clients together
+    ListenerBytes   Rate        // Bytes/sec of all clients together
+    MaxSubs         int         // Subscriptions of each client
+    Policy          LimitPolicy
+}
+
+// Applies a LimitConfig to the clients of a listener, safe for concurrent use
+type Limiter struct {
+    cfg     LimitConfig
+    mu      sync.Mutex
+    pubs    bucket
+    bytes   bucket
+    clients map[string]*clientLimits
+    now     func() time.Time
+}
+
+type clientLimits struct {
+    pubs    bucket
+    bytes   bucket
+    subs    map[string]bool
+}
+
+func NewLimiter(cfg LimitConfig) *Limiter {
+    l := &Limiter{cfg: cfg, clients: make(map[string]*clientLimits), now: time.Now}
+    now := l.now()
+    l.pubs = newBucket(cfg.ListenerPubs, now)
+    l.bytes = newBucket(cfg.ListenerBytes, now)
+    return l
+}
+
+// Accounts m published by the client. With LimitThrottle, returns how long
+// the broker should stop reading from the client. With LimitDisconnect,
+// returns ErrRateLimited if m exceeds a rate, and m isn't accounted.
+func (l *Limiter) Publish(clientId string, m *MsgPublish) (time.Duration, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    c := l.client(clientId)
+    now := l.now()
+    n := float64(publishLen(m))
+    buckets := []*bucket{&c.pubs, &c.bytes, &l.pubs, &l.bytes}
+    amounts := []float64{1, n, 1, n}
+    for _, b := range buckets {
+        b.refill(now)
+    }
+    if l.cfg.Policy == LimitDisconnect {
+        for i, b := range buckets {
+            if !b.has(amounts[i]) {
+                return 0, ErrRateLimited
+            }
+        }
+    }
+    var wait time.Duration
+    for i, b := range buckets {
+        if d := b.take(amounts[i]); d > wait {
+            wait = d
+        }
+    }
+    return wait, nil
+}
+
+// Records a subscription of the client to filter. Returns ErrTooManySubs
+// if the client already has MaxSubs other subscriptions, the broker then
+// refuses the subscription or disconnects, depending on the policy.
+func (l *Limiter) Subscribe(clientId string, filter string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    c := l.client(clientId)
+    if !c.subs[filter] && l.cfg.MaxSubs > 0 && len(c.subs) >= l.cfg.MaxSubs {
+        return ErrTooManySubs
+    }
+    c.subs[filter] = true
+    return nil
+}
+
+// Removes a subscription recorded by Subscribe
+func (l *Limiter) Unsubscribe(clientId string, filter string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if c, ok := l.clients[clientId]; ok {
+        delete(c.subs, filter)
+    }
+}
+
+// Forgets the client, e.g. when its session ends
+func (l *Limiter) Remove(clientId string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    delete(l.clients, clientId)
+}
+
+// l.mu must be held
+func (l *Limiter) client(clientId string) *clientLimits {
+    c, ok := l.clients[clientId]
+    if !ok {
+        now := l.now()
+        c = &clientLimits{
+            pubs:   newBucket(l.cfg.ClientPubs, now),
+            bytes:  newBucket(l.cfg.ClientBytes, now),
+            subs:   make(map[string]bool),
+        }
+        l.clients[clientId] = c
+    }
+    return c
+}
+
+// A token bucket, tokens go negative when taken by a throttled client
+type bucket struct {
+    rate    Rate
+    tokens  float64
+    last    time.Time
+}
+
+func newBucket(r Rate, now time.Time) bucket {
+    if r.Burst == 0 {
+        r.Burst = r.PerSec
+    }
+    return bucket{rate: r, tokens: r.Burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+    if b.rate.PerSec == 0 {
+        return
+    }
+    b.tokens += now.Sub(b.last).Seconds() * b.rate.PerSec
+    if b.tokens > b.rate.Burst {
+        b.tokens = b.rate.Burst
+    }
+    b.last = now
+}
+
+func (b *bucket) has(n float64) bool {
+    return b.rate.PerSec == 0 || b.tokens >= n
+}
+
+// Takes n tokens, returns how long until the bucket is out of debt
+func (b *bucket) take(n float64) time.Duration {
+    if b.rate.PerSec == 0 {
+        return 0
+    }
+    if b.tokens -= n; b.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-b.tokens / b.rate.PerSec * float64(time.Second))
+}
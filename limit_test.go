@@ -0,0 +1,87 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttgo
+
+import (
+    "time"
+    "testing"
+    )
+
+// A limiter with a clock advanced by the test
+func testLimiter(cfg LimitConfig) (*Limiter, *time.Time) {
+    now := time.Unix(1000, 0)
+    l := NewLimiter(cfg)
+    l.now = func() time.Time { return now }
+    l.pubs.last, l.bytes.last = now, now
+    return l, &now
+}
+
+func TestLimiterThrottle(t *testing.T) {
+    l, now := testLimiter(LimitConfig{ClientPubs: Rate{PerSec: 10, Burst: 2}})
+    m := NewPub("a/b", QosAtMostOnce, nil)
+    for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+        if d, err := l.Publish("c1", m); err != nil || d != want {
+            t.Errorf("publish %d: wait %v, %v, want %v", i, d, err, want)
+        }
+    }
+    // Other clients have their own buckets
+    if d, _ := l.Publish("c2", m); d != 0 {
+        t.Errorf("c2 waits %v", d)
+    }
+    *now = now.Add(250 * time.Millisecond)
+    if d, _ := l.Publish("c1", m); d != 50 * time.Millisecond {
+        t.Errorf("wait %v after refill, want 50ms", d)
+    }
+}
+
+func TestLimiterDisconnect(t *testing.T) {
+    // Each message has remaining length 2 + 3 + 5 = 10
+    l, now := testLimiter(LimitConfig{ListenerBytes: Rate{PerSec: 20}, Policy: LimitDisconnect})
+    m := NewPub("a/b", QosAtMostOnce, make([]byte, 5))
+    if _, err := l.Publish("c1", m); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := l.Publish("c2", m); err != nil {
+        t.Fatal(err)
+    }
+    // The listener limit applies to all clients together
+    if _, err := l.Publish("c3", m); err != ErrRateLimited {
+        t.Errorf("got %v, want ErrRateLimited", err)
+    }
+    *now = now.Add(500 * time.Millisecond)
+    if _, err := l.Publish("c3", m); err != nil {
+        t.Errorf("got %v after refill", err)
+    }
+}
+
+func TestLimiterSubs(t *testing.T) {
+    l := NewLimiter(LimitConfig{MaxSubs: 2})
+    for _, f := range []string{"a/#", "b/+", "a/#"} {
+        if err := l.Subscribe("c1", f); err != nil {
+            t.Errorf("subscribing %s: %v", f, err)
+        }
+    }
+    if err := l.Subscribe("c1", "c"); err != ErrTooManySubs {
+        t.Errorf("got %v, want ErrTooManySubs", err)
+    }
+    if err := l.Subscribe("c2", "c"); err != nil {
+        t.Errorf("c2: %v", err)
+    }
+    l.Unsubscribe("c1", "b/+")
+    if err := l.Subscribe("c1", "c"); err != nil {
+        t.Errorf("after unsubscribing: %v", err)
+    }
+}
@@ -29,7 +29,6 @@ package mqttgo
 
 import (
     "io"
-    "log"
     "errors"
     )
 
@@ -139,7 +138,6 @@ func Read(r io.Reader) (Msg, error) {
             if err := msg.readFrom(r, h, l); err != nil {
                 return nil, err
             }
-            log.Printf("READ message type: %d, len %d", t, l)
             return msg, nil
         }
     }
@@ -180,6 +178,61 @@ func NewPubAck(msgid uint16) *MsgPubAck {
     return &m
 }
 
+func NewConnect(clientId string, keepAlive uint16) *MsgConnect {
+    var m MsgConnect
+    m.H.SetType(MsgTypeConnect)
+    m.ProtName = "MQTT"
+    m.ProtVer = 4
+    m.KeepAlive = keepAlive
+    m.ClientId = clientId
+    return &m
+}
+
+func NewPubRec(msgid uint16) *MsgPubRec {
+    var m MsgPubRec
+    m.H.SetType(MsgTypePubRec)
+    m.MsgId = msgid
+    return &m
+}
+
+func NewPubRel(msgid uint16) *MsgPubRel {
+    var m MsgPubRel
+    m.H.SetType(MsgTypePubRel)
+    m.H.SetQos(QosAtLeastOnce)
+    m.MsgId = msgid
+    return &m
+}
+
+func NewPubComp(msgid uint16) *MsgPubComp {
+    var m MsgPubComp
+    m.H.SetType(MsgTypePubComp)
+    m.MsgId = msgid
+    return &m
+}
+
+func NewSubscribe(msgid uint16, qos QosLevel, topics ...string) *MsgSubscribe {
+    var m MsgSubscribe
+    m.H.SetType(MsgTypeSubscribe)
+    m.H.SetQos(QosAtLeastOnce)
+    m.MsgId = msgid
+    for _, t := range topics {
+        m.Topics = append(m.Topics, struct{Topic string; QosLevel}{t, qos})
+    }
+    return &m
+}
+
+func NewPingReq() *MsgPingReq {
+    var m MsgPingReq
+    m.H.SetType(MsgTypePingReq)
+    return &m
+}
+
+func NewDisconnect() *MsgDisconnect {
+    var m MsgDisconnect
+    m.H.SetType(MsgTypeDisconnect)
+    return &m
+}
+
 type MsgPingReq struct {
     msgHeaderOnly
 }
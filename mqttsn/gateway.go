@@ -0,0 +1,562 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements a transparent gateway, which opens an MQTT
+// connection to the broker for every MQTT-SN client.
+//
+// Topic ids are registered per client, by the client with REGISTER for
+// its PUBLISH, and by the gateway for PUBLISH from the broker.
+// The codec has no PUBREC/PUBREL/PUBCOMP, so QoS 2 PUBLISH from clients
+// are refused, and QoS 2 PUBLISH from the broker are completed by the
+// gateway and delivered with QoS 1. Wills aren't supported either, and
+// PUBLISH with QoS -1 are only forwarded for connected clients.
+// A sleeping client gets the messages buffered while it slept when it
+// sends PINGREQ, or when it connects again.
+//
+// Each client is served by its own goroutine, so a slow broker connection
+// only delays its own client. A client silent for 1.5 times its keep alive,
+// or its sleep duration while asleep, is considered lost and its MQTT
+// connection is closed.
+package mqttsn
+
+import (
+    "net"
+    "errors"
+    "sync"
+    "time"
+    "bufio"
+    "bytes"
+    "strings"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Timeout of connecting and writing to the broker
+const DefaultGwTimeout = 10 * time.Second
+
+// Messages from a client waiting for its goroutine, more are dropped
+const sessionQueue = 32
+
+var errNotConnected = errors.New("mqttgo/mqttsn: Not connected to the broker")
+
+// Translates between MQTT-SN clients over UDP and an MQTT broker
+type Gateway struct {
+    Broker      string              // Broker address
+    GwId        uint8
+    Predefined  map[uint16]string   // Topics of pre-defined topic ids, could be nil
+    Timeout     time.Duration       // Of connecting and writing to the broker, DefaultGwTimeout if zero
+    conn        net.PacketConn
+    mu          sync.Mutex
+    sessions    map[string]*session // By client address
+}
+
+// A client connected through the gateway
+type session struct {
+    g           *Gateway
+    clientId    string
+    keepAlive   time.Duration
+    in          chan packet         // From the client
+    broker      net.Conn
+    wmu         sync.Mutex          // Serializes writes to the broker
+    done        chan struct{}
+    closeOnce   sync.Once
+    mu          sync.Mutex
+    addr        net.Addr
+    asleep      bool
+    sleep       time.Duration
+    pingResp    bool                // PINGRESP waits for the registrations of a waking client
+    buffered    []Msg               // Sent when the client wakes
+    topicIds    map[string]uint16
+    topics      map[uint16]string
+    lastTopicId uint16
+    lastMsgId   uint16
+    registering map[uint16]uint16   // TopicId of REGISTER sent, by MsgId
+    waiting     map[uint16][]*mqttgo.MsgPublish // PUBLISH waiting for REGACK, by TopicId
+    acks        map[uint16]uint16   // TopicId of PUBLISH waiting for PUBACK, by MsgId
+    completed   map[uint16]bool     // QoS 2 PUBLISH already completed by the gateway
+}
+
+// A message from a client
+type packet struct {
+    addr    net.Addr
+    m       Msg
+}
+
+// Serves clients reading from conn, until reading fails, e.g. conn is closed.
+// The MQTT connections are closed on return.
+func (g *Gateway) Serve(conn net.PacketConn) error {
+    g.mu.Lock()
+    g.conn = conn
+    g.sessions = make(map[string]*session)
+    g.mu.Unlock()
+    defer g.closeAll()
+    buf := make([]byte, 0xffff)
+    for {
+        n, addr, err := conn.ReadFrom(buf)
+        if err != nil {
+            return err
+        }
+        m, err := Read(bytes.NewReader(buf[:n]))
+        if err != nil {
+            continue // Not a valid message, ignored
+        }
+        g.handle(addr, m)
+    }
+}
+
+func (g *Gateway) handle(addr net.Addr, m Msg) {
+    switch m := m.(type) {
+    case *MsgSearchGw:
+        g.send(addr, &MsgGwInfo{GwId: g.GwId})
+        return
+    case *MsgConnect:
+        g.connect(addr, m)
+        return
+    }
+    g.mu.Lock()
+    s := g.sessions[addr.String()]
+    if ping, ok := m.(*MsgPingReq); ok && s == nil && ping.ClientId != "" {
+        // A sleeping client could wake up with another address
+        for key, other := range g.sessions {
+            if other.clientId == ping.ClientId {
+                s = other
+                delete(g.sessions, key)
+                g.sessions[addr.String()] = s
+                break
+            }
+        }
+    }
+    g.mu.Unlock()
+    if s != nil {
+        s.post(addr, m)
+    }
+}
+
+func (g *Gateway) connect(addr net.Addr, m *MsgConnect) {
+    if m.Flags.Will() {
+        g.send(addr, &MsgConnAck{RC: RCNotSupported})
+        return
+    }
+    g.mu.Lock()
+    old := g.sessions[addr.String()]
+    if old != nil && old.clientId == m.ClientId && old.wake(addr) {
+        g.mu.Unlock()
+        old.post(addr, m)
+        return
+    }
+    s := &session{
+        g:           g,
+        clientId:    m.ClientId,
+        keepAlive:   time.Duration(m.Duration) * time.Second,
+        in:          make(chan packet, sessionQueue),
+        done:        make(chan struct{}),
+        addr:        addr,
+        topicIds:    make(map[string]uint16),
+        topics:      make(map[uint16]string),
+        registering: make(map[uint16]uint16),
+        waiting:     make(map[uint16][]*mqttgo.MsgPublish),
+        acks:        make(map[uint16]uint16),
+        completed:   make(map[uint16]bool),
+    }
+    g.sessions[addr.String()] = s
+    g.mu.Unlock()
+    if old != nil {
+        go old.close()
+    }
+    go s.run(m)
+    go s.serve()
+}
+
+// Removes s, if it's still the session of its client, and closes it
+func (g *Gateway) remove(s *session) {
+    g.mu.Lock()
+    for key, other := range g.sessions {
+        if other == s {
+            delete(g.sessions, key)
+        }
+    }
+    g.mu.Unlock()
+    s.close()
+}
+
+func (g *Gateway) closeAll() {
+    g.mu.Lock()
+    sessions := g.sessions
+    g.sessions = make(map[string]*session)
+    g.mu.Unlock()
+    for _, s := range sessions {
+        s.close()
+    }
+}
+
+// Sends m to the client at addr
+func (g *Gateway) send(addr net.Addr, m Msg) error {
+    var b bytes.Buffer
+    if err := Write(&b, m); err != nil {
+        return err
+    }
+    _, err := g.conn.WriteTo(b.Bytes(), addr)
+    return err
+}
+
+func (g *Gateway) timeout() time.Duration {
+    if g.Timeout == 0 {
+        return DefaultGwTimeout
+    }
+    return g.Timeout
+}
+
+// Connects to the broker and forwards its messages to the client
+func (s *session) run(m *MsgConnect) {
+    timeout := s.g.timeout()
+    c, err := net.DialTimeout("tcp", s.g.Broker, timeout)
+    if err != nil {
+        s.toClient(&MsgConnAck{RC: RCCongestion})
+        s.g.remove(s)
+        return
+    }
+    r := bufio.NewReader(c)
+    c.SetDeadline(time.Now().Add(timeout))
+    var ack mqttgo.Msg
+    if err = mqttgo.Write(c, m.Mqtt()); err == nil {
+        ack, err = mqttgo.Read(r)
+    }
+    c.SetDeadline(time.Time{})
+    if ca, ok := ack.(*mqttgo.MsgConnAck); err != nil || !ok || ca.RC != mqttgo.RCAccepted {
+        c.Close()
+        s.toClient(&MsgConnAck{RC: RCNotSupported})
+        s.g.remove(s)
+        return
+    }
+    s.mu.Lock()
+    s.broker = c
+    s.mu.Unlock()
+    select {
+    case <-s.done:
+        // Replaced or closed while connecting
+        c.Close()
+        return
+    default:
+    }
+    s.toClient(&MsgConnAck{RC: RCAccepted})
+    if s.keepAlive > 0 {
+        go s.ping()
+    }
+    for {
+        m, err := mqttgo.Read(r)
+        if err != nil {
+            break
+        }
+        s.fromBroker(m)
+    }
+    select {
+    case <-s.done:
+    default:
+        // The broker closed the connection
+        s.toClient(&MsgDisconnect{})
+        s.g.remove(s)
+    }
+}
+
+// Keeps the MQTT connection alive, also while the client sleeps
+func (s *session) ping() {
+    t := time.NewTicker(s.keepAlive / 2)
+    defer t.Stop()
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-t.C:
+            if s.toBroker(mqttgo.NewPingReq()) != nil {
+                return
+            }
+        }
+    }
+}
+
+// Handles the messages from the client until the session is closed,
+// removes the session when the client is silent for too long
+func (s *session) serve() {
+    for {
+        var t *time.Timer
+        var expired <-chan time.Time
+        if d := s.timeout(); d > 0 {
+            t = time.NewTimer(d)
+            expired = t.C
+        }
+        select {
+        case p := <-s.in:
+            s.handle(p.addr, p.m)
+        case <-expired:
+            // Lost without DISCONNECT
+            s.g.remove(s)
+        case <-s.done:
+        }
+        if t != nil {
+            t.Stop()
+        }
+        select {
+        case <-s.done:
+            return
+        default:
+        }
+    }
+}
+
+// Returns how long the client can be silent, zero for no limit
+func (s *session) timeout() time.Duration {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.asleep {
+        return s.sleep * 3 / 2
+    }
+    return s.keepAlive * 3 / 2
+}
+
+// Queues m for the goroutine of s, drops it if the queue is full
+func (s *session) post(addr net.Addr, m Msg) {
+    select {
+    case s.in <- packet{addr, m}:
+    default:
+    }
+}
+
+func (s *session) close() {
+    s.closeOnce.Do(func() {
+        close(s.done)
+        s.mu.Lock()
+        c := s.broker
+        s.mu.Unlock()
+        if c != nil {
+            s.toBroker(mqttgo.NewDisconnect())
+            c.Close()
+        }
+    })
+}
+
+// Handles a message from the client
+func (s *session) handle(addr net.Addr, m Msg) {
+    switch m := m.(type) {
+    case *MsgConnect:
+        // Woken up by connecting again
+        s.toClient(&MsgConnAck{RC: RCAccepted})
+        s.flush()
+    case *MsgRegister:
+        rc := RCAccepted
+        id := uint16(0)
+        if m.TopicName == "" || strings.ContainsAny(m.TopicName, "+#") {
+            rc = RCNotSupported
+        } else {
+            s.mu.Lock()
+            id = s.topicId(m.TopicName)
+            s.mu.Unlock()
+        }
+        s.toClient(&MsgRegAck{TopicId: id, MsgId: m.MsgId, RC: rc})
+    case *MsgRegAck:
+        s.mu.Lock()
+        id := s.registering[m.MsgId]
+        delete(s.registering, m.MsgId)
+        pubs := s.waiting[id]
+        delete(s.waiting, id)
+        s.mu.Unlock()
+        if m.RC == RCAccepted {
+            for _, p := range pubs {
+                s.deliver(p, id)
+            }
+        }
+        s.mu.Lock()
+        resp := s.pingResp && len(s.registering) == 0
+        if resp {
+            s.pingResp = false
+        }
+        s.mu.Unlock()
+        if resp {
+            s.g.send(addr, &MsgPingResp{})
+        }
+    case *MsgPublish:
+        s.publish(m)
+    case *MsgPubAck:
+        s.mu.Lock()
+        completed := s.completed[m.MsgId]
+        delete(s.completed, m.MsgId)
+        s.mu.Unlock()
+        if !completed {
+            s.toBroker(mqttgo.NewPubAck(m.MsgId))
+        }
+    case *MsgPingReq:
+        s.mu.Lock()
+        s.addr = addr
+        s.mu.Unlock()
+        s.flush()
+        s.mu.Lock()
+        // Stays awake until the topics of the buffered PUBLISH are registered
+        s.pingResp = s.asleep && len(s.registering) > 0
+        resp := !s.pingResp
+        s.mu.Unlock()
+        if resp {
+            s.g.send(addr, &MsgPingResp{})
+        }
+    case *MsgDisconnect:
+        if m.Duration > 0 {
+            s.mu.Lock()
+            s.asleep = true
+            s.sleep = time.Duration(m.Duration) * time.Second
+            s.mu.Unlock()
+            s.g.send(addr, &MsgDisconnect{})
+            return
+        }
+        s.g.send(addr, &MsgDisconnect{})
+        s.g.remove(s)
+    }
+}
+
+// Forwards a PUBLISH from the client to the broker
+func (s *session) publish(m *MsgPublish) {
+    var topic string
+    var ok bool
+    switch m.Flags.TopicIdType() {
+    case TopicIdNormal:
+        s.mu.Lock()
+        topic, ok = s.topics[m.TopicId]
+        s.mu.Unlock()
+    case TopicIdPredef:
+        topic, ok = s.g.Predefined[m.TopicId]
+    case TopicIdShort:
+        topic, ok = m.ShortTopic(), true
+    }
+    qos := m.Flags.Qos()
+    if !ok || qos == mqttgo.QosExactlyOnce {
+        rc := RCInvalidTopicId
+        if ok {
+            rc = RCNotSupported
+        }
+        s.toClient(&MsgPubAck{TopicId: m.TopicId, MsgId: m.MsgId, RC: rc})
+        return
+    }
+    if qos == mqttgo.QosAtLeastOnce {
+        s.mu.Lock()
+        s.acks[m.MsgId] = m.TopicId
+        s.mu.Unlock()
+    }
+    s.toBroker(m.Mqtt(topic))
+}
+
+// Handles a message from the broker
+func (s *session) fromBroker(m mqttgo.Msg) {
+    switch m := m.(type) {
+    case *mqttgo.MsgPublish:
+        if qos, _ := m.H.Qos(); qos == mqttgo.QosExactlyOnce {
+            s.toBroker(mqttgo.NewPubRec(m.MsgId))
+            m.H.SetQos(mqttgo.QosAtLeastOnce)
+            s.mu.Lock()
+            s.completed[m.MsgId] = true
+            s.mu.Unlock()
+        }
+        s.mu.Lock()
+        id, ok := s.topicIds[m.Topic]
+        if _, pending := s.waiting[id]; ok && pending {
+            s.waiting[id] = append(s.waiting[id], m)
+            s.mu.Unlock()
+            return
+        } else if !ok {
+            id = s.topicId(m.Topic)
+            s.lastMsgId++
+            reg := &MsgRegister{TopicId: id, MsgId: s.lastMsgId, TopicName: m.Topic}
+            s.registering[reg.MsgId] = id
+            s.waiting[id] = []*mqttgo.MsgPublish{m}
+            s.mu.Unlock()
+            s.toClient(reg)
+            return
+        }
+        s.mu.Unlock()
+        s.deliver(m, id)
+    case *mqttgo.MsgPubAck:
+        s.mu.Lock()
+        id, ok := s.acks[m.MsgId]
+        delete(s.acks, m.MsgId)
+        s.mu.Unlock()
+        if ok {
+            s.toClient(&MsgPubAck{TopicId: id, MsgId: m.MsgId, RC: RCAccepted})
+        }
+    case *mqttgo.MsgPubRel:
+        s.toBroker(mqttgo.NewPubComp(m.MsgId))
+    }
+}
+
+func (s *session) deliver(p *mqttgo.MsgPublish, id uint16) {
+    if m, err := NewPublish(p, id); err == nil {
+        s.toClient(m)
+    }
+}
+
+// Returns the topic id of name, registering it if needed, s.mu must be held
+func (s *session) topicId(name string) uint16 {
+    if id, ok := s.topicIds[name]; ok {
+        return id
+    }
+    s.lastTopicId++
+    s.topicIds[name] = s.lastTopicId
+    s.topics[s.lastTopicId] = name
+    return s.lastTopicId
+}
+
+// Sends m to the client, or buffers it while the client sleeps
+func (s *session) toClient(m Msg) {
+    s.mu.Lock()
+    if s.asleep && !s.pingResp {
+        s.buffered = append(s.buffered, m)
+        s.mu.Unlock()
+        return
+    }
+    addr := s.addr
+    s.mu.Unlock()
+    s.g.send(addr, m)
+}
+
+func (s *session) toBroker(m mqttgo.Msg) error {
+    s.mu.Lock()
+    c := s.broker
+    s.mu.Unlock()
+    if c == nil {
+        return errNotConnected
+    }
+    s.wmu.Lock()
+    defer s.wmu.Unlock()
+    c.SetWriteDeadline(time.Now().Add(s.g.timeout()))
+    return mqttgo.Write(c, m)
+}
+
+// Sends the messages buffered while the client slept
+func (s *session) flush() {
+    s.mu.Lock()
+    msgs, addr := s.buffered, s.addr
+    s.buffered = nil
+    s.mu.Unlock()
+    for _, m := range msgs {
+        s.g.send(addr, m)
+    }
+}
+
+// Ends sleeping when the client connects again, returns false if not asleep
+func (s *session) wake(addr net.Addr) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if !s.asleep {
+        return false
+    }
+    s.asleep = false
+    s.pingResp = false
+    s.addr = addr
+    return true
+}
@@ -0,0 +1,247 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttsn
+
+import (
+    "net"
+    "time"
+    "bytes"
+    "testing"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Starts a gateway on loopback UDP and a broker accepting one connection,
+// returns the client side of both
+func startGateway(t *testing.T) (net.Conn, <-chan net.Conn) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+    brokers := make(chan net.Conn, 1)
+    go func() {
+        c, err := l.Accept()
+        if err == nil {
+            brokers <- c
+        }
+    }()
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { pc.Close() })
+    g := &Gateway{Broker: l.Addr().String(), GwId: 7, Timeout: time.Second}
+    go g.Serve(pc)
+    c, err := net.Dial("udp", pc.LocalAddr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { c.Close() })
+    return c, brokers
+}
+
+func send(t *testing.T, c net.Conn, m Msg) {
+    var b bytes.Buffer
+    if err := Write(&b, m); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := c.Write(b.Bytes()); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func recv(t *testing.T, c net.Conn) Msg {
+    buf := make([]byte, 0xffff)
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    n, err := c.Read(buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    m, err := Read(bytes.NewReader(buf[:n]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return m
+}
+
+func recvMqtt(t *testing.T, c net.Conn) mqttgo.Msg {
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    m, err := mqttgo.Read(c)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return m
+}
+
+// Connects the client through the gateway, returns the broker side
+func connect(t *testing.T, c net.Conn, brokers <-chan net.Conn) net.Conn {
+    m := &MsgConnect{Duration: 60, ClientId: "sensor1"}
+    m.Flags.SetCleanSession(true)
+    send(t, c, m)
+    var b net.Conn
+    select {
+    case b = <-brokers:
+    case <-time.After(2 * time.Second):
+        t.Fatal("gateway didn't connect to the broker")
+    }
+    t.Cleanup(func() { b.Close() })
+    conn, ok := recvMqtt(t, b).(*mqttgo.MsgConnect)
+    if !ok || conn.ClientId != "sensor1" || conn.ProtName != "MQTT" || conn.KeepAlive != 60 {
+        t.Fatalf("broker got CONNECT %+v", conn)
+    }
+    if err := mqttgo.Write(b, mqttgo.NewConnAck(mqttgo.RCAccepted)); err != nil {
+        t.Fatal(err)
+    }
+    if ack, ok := recv(t, c).(*MsgConnAck); !ok || ack.RC != RCAccepted {
+        t.Fatalf("client got %#v, want CONNACK", ack)
+    }
+    return b
+}
+
+func TestGatewayPublish(t *testing.T) {
+    c, brokers := startGateway(t)
+    send(t, c, &MsgSearchGw{Radius: 1})
+    if info, ok := recv(t, c).(*MsgGwInfo); !ok || info.GwId != 7 {
+        t.Fatalf("got %#v, want GWINFO", info)
+    }
+    b := connect(t, c, brokers)
+
+    // Client to broker, with a registered topic id
+    send(t, c, &MsgRegister{MsgId: 1, TopicName: "sensors/temp"})
+    regAck, ok := recv(t, c).(*MsgRegAck)
+    if !ok || regAck.RC != RCAccepted || regAck.MsgId != 1 {
+        t.Fatalf("got %#v, want REGACK", regAck)
+    }
+    pub := &MsgPublish{TopicId: regAck.TopicId, MsgId: 2, Data: []byte("21.5")}
+    pub.Flags.SetQos(mqttgo.QosAtLeastOnce)
+    send(t, c, pub)
+    p, ok := recvMqtt(t, b).(*mqttgo.MsgPublish)
+    if !ok || p.Topic != "sensors/temp" || string(p.Content) != "21.5" || p.MsgId != 2 {
+        t.Fatalf("broker got %#v, want PUBLISH", p)
+    }
+    mqttgo.Write(b, mqttgo.NewPubAck(2))
+    if ack, ok := recv(t, c).(*MsgPubAck); !ok || ack.MsgId != 2 || ack.TopicId != regAck.TopicId {
+        t.Fatalf("got %#v, want PUBACK", ack)
+    }
+
+    // Unknown topic id
+    send(t, c, &MsgPublish{TopicId: 99, MsgId: 3})
+    if ack, ok := recv(t, c).(*MsgPubAck); !ok || ack.RC != RCInvalidTopicId {
+        t.Fatalf("got %#v, want PUBACK with RCInvalidTopicId", ack)
+    }
+
+    // Broker to client, the gateway registers the topic first
+    mqttgo.Write(b, mqttgo.NewPub("cmd/led", mqttgo.QosAtMostOnce, []byte("on")))
+    reg, ok := recv(t, c).(*MsgRegister)
+    if !ok || reg.TopicName != "cmd/led" {
+        t.Fatalf("got %#v, want REGISTER", reg)
+    }
+    send(t, c, &MsgRegAck{TopicId: reg.TopicId, MsgId: reg.MsgId, RC: RCAccepted})
+    if got, ok := recv(t, c).(*MsgPublish); !ok || got.TopicId != reg.TopicId || string(got.Data) != "on" {
+        t.Fatalf("got %#v, want PUBLISH", got)
+    }
+
+    send(t, c, &MsgDisconnect{})
+    if _, ok := recv(t, c).(*MsgDisconnect); !ok {
+        t.Fatal("want DISCONNECT")
+    }
+    if _, ok := recvMqtt(t, b).(*mqttgo.MsgDisconnect); !ok {
+        t.Fatal("broker want DISCONNECT")
+    }
+}
+
+func TestGatewaySleep(t *testing.T) {
+    c, brokers := startGateway(t)
+    b := connect(t, c, brokers)
+    send(t, c, &MsgDisconnect{Duration: 600})
+    if _, ok := recv(t, c).(*MsgDisconnect); !ok {
+        t.Fatal("want DISCONNECT")
+    }
+
+    // Buffered while asleep, registered and delivered on PINGREQ
+    mqttgo.Write(b, mqttgo.NewPub("cmd/led", mqttgo.QosAtMostOnce, []byte("off")))
+    time.Sleep(100 * time.Millisecond)
+    send(t, c, &MsgPingReq{ClientId: "sensor1"})
+    reg, ok := recv(t, c).(*MsgRegister)
+    if !ok || reg.TopicName != "cmd/led" {
+        t.Fatalf("got %#v, want REGISTER", reg)
+    }
+    send(t, c, &MsgRegAck{TopicId: reg.TopicId, MsgId: reg.MsgId, RC: RCAccepted})
+    if got, ok := recv(t, c).(*MsgPublish); !ok || string(got.Data) != "off" {
+        t.Fatalf("got %#v, want PUBLISH", got)
+    }
+    if _, ok := recv(t, c).(*MsgPingResp); !ok {
+        t.Fatal("want PINGRESP")
+    }
+
+    // Nothing buffered
+    send(t, c, &MsgPingReq{ClientId: "sensor1"})
+    if _, ok := recv(t, c).(*MsgPingResp); !ok {
+        t.Fatal("want PINGRESP")
+    }
+}
+
+// Waits for the gateway to close the broker connection, skipping PINGREQ
+func waitClosed(t *testing.T, b net.Conn, within time.Duration) {
+    b.SetReadDeadline(time.Now().Add(within))
+    for {
+        m, err := mqttgo.Read(b)
+        if err != nil {
+            if ne, ok := err.(net.Error); ok && ne.Timeout() {
+                t.Fatal("broker connection not closed")
+            }
+            return
+        }
+        if _, ok := m.(*mqttgo.MsgPingReq); !ok {
+            return
+        }
+    }
+}
+
+func TestGatewayLost(t *testing.T) {
+    c, brokers := startGateway(t)
+    send(t, c, &MsgConnect{Duration: 1, ClientId: "sensor1"})
+    b := <-brokers
+    defer b.Close()
+    if _, err := mqttgo.Read(b); err != nil {
+        t.Fatal(err)
+    }
+    mqttgo.Write(b, mqttgo.NewConnAck(mqttgo.RCAccepted))
+    recv(t, c)
+    start := time.Now()
+
+    // PINGREQ keeps the client alive
+    time.Sleep(time.Second)
+    send(t, c, &MsgPingReq{})
+    if _, ok := recv(t, c).(*MsgPingResp); !ok {
+        t.Fatal("want PINGRESP")
+    }
+    waitClosed(t, b, 3 * time.Second)
+    if d := time.Since(start); d < 2 * time.Second {
+        t.Errorf("session removed after %v, before 1.5 keep alives since PINGREQ", d)
+    }
+}
+
+func TestGatewayLostAsleep(t *testing.T) {
+    c, brokers := startGateway(t)
+    b := connect(t, c, brokers)
+    send(t, c, &MsgDisconnect{Duration: 1})
+    if _, ok := recv(t, c).(*MsgDisconnect); !ok {
+        t.Fatal("want DISCONNECT")
+    }
+    // The keep alive of 60 seconds no longer applies
+    waitClosed(t, b, 3 * time.Second)
+}
@@ -0,0 +1,390 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements MQTT-SN messages
+// - MsgAdvertise, MsgSearchGw, MsgGwInfo
+// - MsgConnect, MsgConnAck
+// - MsgRegister, MsgRegAck
+// - MsgPublish, MsgPubAck
+// - MsgPingReq, MsgPingResp
+// - MsgDisconnect
+// and the conversions between MQTT-SN and MQTT messages
+package mqttsn
+
+import (
+    "io"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+type MsgAdvertise struct {
+    GwId        uint8
+    Duration    uint16  // Seconds until the next ADVERTISE
+}
+
+type MsgSearchGw struct {
+    Radius  uint8
+}
+
+type MsgGwInfo struct {
+    GwId    uint8
+    GwAdd   []byte  // Only present when sent by a client
+}
+
+type MsgConnect struct {
+    Flags       Flags
+    Duration    uint16  // Keep alive timer
+    ClientId    string
+}
+
+type MsgConnAck struct {
+    RC  ReturnCode
+}
+
+type MsgRegister struct {
+    TopicId     uint16
+    MsgId       uint16
+    TopicName   string
+}
+
+type MsgRegAck struct {
+    TopicId uint16
+    MsgId   uint16
+    RC      ReturnCode
+}
+
+type MsgPublish struct {
+    Flags   Flags
+    TopicId uint16
+    MsgId   uint16
+    Data    []byte
+}
+
+type MsgPubAck struct {
+    TopicId uint16
+    MsgId   uint16
+    RC      ReturnCode
+}
+
+type MsgPingReq struct {
+    ClientId    string  // Only present when sent by a sleeping client
+}
+
+type MsgPingResp struct {
+}
+
+type MsgDisconnect struct {
+    Duration    uint16  // Sleep duration, zero when not sleeping
+}
+
+func (m *MsgAdvertise) Type() MsgType {
+    return MsgTypeAdvertise
+}
+
+func (m *MsgAdvertise) readFrom(r io.Reader, length int) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if m.GwId, err = readUint8(lr); err != nil {
+        return err
+    } else if m.Duration, err = readUint16(lr); err != nil {
+        return err
+    } else if lr.N != 0 {
+        return ErrBadLength
+    }
+    return nil
+}
+
+func (m *MsgAdvertise) body() ([]byte, error) {
+    return appendUint16([]byte{m.GwId}, m.Duration), nil
+}
+
+func (m *MsgSearchGw) Type() MsgType {
+    return MsgTypeSearchGw
+}
+
+func (m *MsgSearchGw) readFrom(r io.Reader, length int) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if m.Radius, err = readUint8(lr); err != nil {
+        return err
+    } else if lr.N != 0 {
+        return ErrBadLength
+    }
+    return nil
+}
+
+func (m *MsgSearchGw) body() ([]byte, error) {
+    return []byte{m.Radius}, nil
+}
+
+func (m *MsgGwInfo) Type() MsgType {
+    return MsgTypeGwInfo
+}
+
+func (m *MsgGwInfo) readFrom(r io.Reader, length int) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if m.GwId, err = readUint8(lr); err != nil {
+        return err
+    } else if m.GwAdd, err = readRest(lr); err != nil {
+        return err
+    }
+    return nil
+}
+
+func (m *MsgGwInfo) body() ([]byte, error) {
+    return append([]byte{m.GwId}, m.GwAdd...), nil
+}
+
+func (m *MsgConnect) Type() MsgType {
+    return MsgTypeConnect
+}
+
+func (m *MsgConnect) readFrom(r io.Reader, length int) error {
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if f, err := readUint8(lr); err != nil {
+        return err
+    } else if pid, err := readUint8(lr); err != nil {
+        return err
+    } else if pid != ProtocolId {
+        return ErrBadProtocolId
+    } else if m.Duration, err = readUint16(lr); err != nil {
+        return err
+    } else if id, err := readRest(lr); err != nil {
+        return err
+    } else {
+        m.Flags = Flags(f)
+        m.ClientId = string(id)
+    }
+    return nil
+}
+
+func (m *MsgConnect) body() ([]byte, error) {
+    p := appendUint16([]byte{byte(m.Flags), ProtocolId}, m.Duration)
+    return append(p, m.ClientId...), nil
+}
+
+// Converts to the MQTT CONNECT sent by the gateway on behalf of the client
+func (m *MsgConnect) Mqtt() *mqttgo.MsgConnect {
+    c := mqttgo.NewConnect(m.ClientId, m.Duration)
+    c.SetCleanSession(m.Flags.CleanSession())
+    return c
+}
+
+func (m *MsgConnAck) Type() MsgType {
+    return MsgTypeConnAck
+}
+
+func (m *MsgConnAck) readFrom(r io.Reader, length int) error {
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if rc, err := readUint8(lr); err != nil {
+        return err
+    } else {
+        m.RC = ReturnCode(rc)
+    }
+    if lr.N != 0 {
+        return ErrBadLength
+    }
+    return nil
+}
+
+func (m *MsgConnAck) body() ([]byte, error) {
+    return []byte{byte(m.RC)}, nil
+}
+
+func (m *MsgRegister) Type() MsgType {
+    return MsgTypeRegister
+}
+
+func (m *MsgRegister) readFrom(r io.Reader, length int) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if m.TopicId, err = readUint16(lr); err != nil {
+        return err
+    } else if m.MsgId, err = readUint16(lr); err != nil {
+        return err
+    } else if name, err := readRest(lr); err != nil {
+        return err
+    } else {
+        m.TopicName = string(name)
+    }
+    return nil
+}
+
+func (m *MsgRegister) body() ([]byte, error) {
+    p := appendUint16(appendUint16(nil, m.TopicId), m.MsgId)
+    return append(p, m.TopicName...), nil
+}
+
+func (m *MsgRegAck) Type() MsgType {
+    return MsgTypeRegAck
+}
+
+func (m *MsgRegAck) readFrom(r io.Reader, length int) error {
+    return readAck(r, length, &m.TopicId, &m.MsgId, &m.RC)
+}
+
+func (m *MsgRegAck) body() ([]byte, error) {
+    return ackBody(m.TopicId, m.MsgId, m.RC), nil
+}
+
+func (m *MsgPublish) Type() MsgType {
+    return MsgTypePublish
+}
+
+func (m *MsgPublish) readFrom(r io.Reader, length int) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if f, err := readUint8(lr); err != nil {
+        return err
+    } else {
+        m.Flags = Flags(f)
+    }
+    if m.TopicId, err = readUint16(lr); err != nil {
+        return err
+    } else if m.MsgId, err = readUint16(lr); err != nil {
+        return err
+    } else if m.Data, err = readRest(lr); err != nil {
+        return err
+    }
+    return nil
+}
+
+func (m *MsgPublish) body() ([]byte, error) {
+    p := appendUint16(appendUint16([]byte{byte(m.Flags)}, m.TopicId), m.MsgId)
+    return append(p, m.Data...), nil
+}
+
+// Returns the topic name carried in TopicId when the type is TopicIdShort
+func (m *MsgPublish) ShortTopic() string {
+    return string([]byte{byte(m.TopicId >> 8), byte(m.TopicId & 0x00ff)})
+}
+
+// Converts to MQTT PUBLISH, topic is the name TopicId stands for
+func (m *MsgPublish) Mqtt(topic string) *mqttgo.MsgPublish {
+    p := mqttgo.NewPub(topic, m.Flags.Qos(), m.Data)
+    p.H.SetDup(m.Flags.Dup())
+    p.H.SetRetain(m.Flags.Retain())
+    p.MsgId = m.MsgId
+    return p
+}
+
+// Converts MQTT PUBLISH to MQTT-SN, topicId is the id registered for p.Topic
+func NewPublish(p *mqttgo.MsgPublish, topicId uint16) (*MsgPublish, error) {
+    qos, err := p.H.Qos()
+    if err != nil {
+        return nil, err
+    }
+    m := &MsgPublish{TopicId: topicId, MsgId: p.MsgId, Data: p.Content}
+    m.Flags.SetQos(qos)
+    m.Flags.SetDup(p.H.Dup())
+    m.Flags.SetRetain(p.H.Retain())
+    m.Flags.SetTopicIdType(TopicIdNormal)
+    return m, nil
+}
+
+func (m *MsgPubAck) Type() MsgType {
+    return MsgTypePubAck
+}
+
+func (m *MsgPubAck) readFrom(r io.Reader, length int) error {
+    return readAck(r, length, &m.TopicId, &m.MsgId, &m.RC)
+}
+
+func (m *MsgPubAck) body() ([]byte, error) {
+    return ackBody(m.TopicId, m.MsgId, m.RC), nil
+}
+
+func (m *MsgPingReq) Type() MsgType {
+    return MsgTypePingReq
+}
+
+func (m *MsgPingReq) readFrom(r io.Reader, length int) error {
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if id, err := readRest(lr); err != nil {
+        return err
+    } else {
+        m.ClientId = string(id)
+    }
+    return nil
+}
+
+func (m *MsgPingReq) body() ([]byte, error) {
+    return []byte(m.ClientId), nil
+}
+
+func (m *MsgPingResp) Type() MsgType {
+    return MsgTypePingResp
+}
+
+func (m *MsgPingResp) readFrom(r io.Reader, length int) error {
+    if length != 0 {
+        return ErrBadLength
+    }
+    return nil
+}
+
+func (m *MsgPingResp) body() ([]byte, error) {
+    return nil, nil
+}
+
+func (m *MsgDisconnect) Type() MsgType {
+    return MsgTypeDisconnect
+}
+
+func (m *MsgDisconnect) readFrom(r io.Reader, length int) error {
+    var err error
+    switch length {
+    case 0:
+        m.Duration = 0
+    case 2:
+        if m.Duration, err = readUint16(r); err != nil {
+            return err
+        }
+    default:
+        return ErrBadLength
+    }
+    return nil
+}
+
+func (m *MsgDisconnect) body() ([]byte, error) {
+    if m.Duration == 0 {
+        return nil, nil
+    }
+    return appendUint16(nil, m.Duration), nil
+}
+
+// Helper function for reading REGACK and PUBACK
+func readAck(r io.Reader, length int, topicId *uint16, msgId *uint16, rc *ReturnCode) error {
+    var err error
+    lr := &io.LimitedReader{R: r, N: int64(length)}
+    if *topicId, err = readUint16(lr); err != nil {
+        return err
+    } else if *msgId, err = readUint16(lr); err != nil {
+        return err
+    } else if c, err := readUint8(lr); err != nil {
+        return err
+    } else {
+        *rc = ReturnCode(c)
+    }
+    if lr.N != 0 {
+        return ErrBadLength
+    }
+    return nil
+}
+
+// Helper function for writing REGACK and PUBACK
+func ackBody(topicId uint16, msgId uint16, rc ReturnCode) []byte {
+    return append(appendUint16(appendUint16(nil, topicId), msgId), byte(rc))
+}
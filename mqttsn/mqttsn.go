@@ -0,0 +1,282 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package mqttsn implements encoding/decoding MQTT-SN v1.2 messages.
+//
+// Message format:
+// |------------------------------------------------------------------|
+// | byte1      | Length, or 0x01 followed by a 2 bytes length        |
+// |------------------------------------------------------------------|
+// | byte2 or 4 | Message Type                                        |
+// |------------------------------------------------------------------|
+// |                            Message Variable Part                 |
+// |------------------------------------------------------------------|
+// The length counts the whole message, including the length field itself.
+// Every message is sent in a single UDP datagram, so a message is
+// usually decoded from a bytes.Reader over the datagram.
+package mqttsn
+
+import (
+    "io"
+    "errors"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+const (
+    MsgTypeAdvertise    MsgType = 0x00
+    MsgTypeSearchGw     MsgType = 0x01
+    MsgTypeGwInfo       MsgType = 0x02
+    MsgTypeConnect      MsgType = 0x04
+    MsgTypeConnAck      MsgType = 0x05
+    MsgTypeRegister     MsgType = 0x0A
+    MsgTypeRegAck       MsgType = 0x0B
+    MsgTypePublish      MsgType = 0x0C
+    MsgTypePubAck       MsgType = 0x0D
+    MsgTypePingReq      MsgType = 0x16
+    MsgTypePingResp     MsgType = 0x17
+    MsgTypeDisconnect   MsgType = 0x18
+    )
+
+const (
+    RCAccepted ReturnCode = iota
+    RCCongestion
+    RCInvalidTopicId
+    RCNotSupported
+    )
+
+const (
+    TopicIdNormal   TopicIdType = iota  // Registered topic id
+    TopicIdPredef                       // Pre-defined topic id
+    TopicIdShort                        // Short topic name, 2 chars
+    )
+
+// The protocol id in CONNECT
+const ProtocolId = 0x01
+
+// Errors could happen when reading Msg
+var (
+    ErrBadMsgType = errors.New("mqttgo/mqttsn: Bad message type")
+    ErrBadLength = errors.New("mqttgo/mqttsn: Bad message length")
+    ErrBadProtocolId = errors.New("mqttgo/mqttsn: Bad protocol id")
+    )
+
+// A registry for creating Msg objects
+var msgRegistry map[MsgType]func() Msg = map[MsgType]func() Msg {
+    MsgTypeAdvertise:   func() Msg { return new(MsgAdvertise) },
+    MsgTypeSearchGw:    func() Msg { return new(MsgSearchGw) },
+    MsgTypeGwInfo:      func() Msg { return new(MsgGwInfo) },
+    MsgTypeConnect:     func() Msg { return new(MsgConnect) },
+    MsgTypeConnAck:     func() Msg { return new(MsgConnAck) },
+    MsgTypeRegister:    func() Msg { return new(MsgRegister) },
+    MsgTypeRegAck:      func() Msg { return new(MsgRegAck) },
+    MsgTypePublish:     func() Msg { return new(MsgPublish) },
+    MsgTypePubAck:      func() Msg { return new(MsgPubAck) },
+    MsgTypePingReq:     func() Msg { return new(MsgPingReq) },
+    MsgTypePingResp:    func() Msg { return new(MsgPingResp) },
+    MsgTypeDisconnect:  func() Msg { return new(MsgDisconnect) },
+}
+
+// All MQTT-SN messages implement this interface
+type Msg interface {
+    // Returns the type of Msg
+    Type() MsgType
+    // Decode Msg from r, the length and the type is already read,
+    // length is the length of the variable part
+    readFrom(r io.Reader, length int) error
+    // Encode the variable part of Msg
+    body() ([]byte, error)
+}
+
+type MsgType uint8
+
+type ReturnCode uint8
+
+func (c ReturnCode) Valid() bool {
+    return c <= RCNotSupported
+}
+
+type TopicIdType uint8
+
+// Flags field of CONNECT, PUBLISH, etc.
+type Flags byte
+
+// Getter of Dup flag
+func (f Flags) Dup() bool {
+    return (f & 0x80) != 0
+}
+
+// Setter of Dup flag
+func (f *Flags) SetDup(v bool) {
+    f.set(v, 0x80)
+}
+
+// Getter of Qos level, QoS level -1 is reported as QosAtMostOnce
+func (f Flags) Qos() mqttgo.QosLevel {
+    if f.QosMinusOne() {
+        return mqttgo.QosAtMostOnce
+    }
+    return mqttgo.QosLevel((f >> 5) & 0x03)
+}
+
+// Setter of Qos level
+func (f *Flags) SetQos(l mqttgo.QosLevel) error {
+    if !l.Valid() {
+        return mqttgo.ErrBadQosLevel
+    }
+    *f = (*f &^ 0x60) | Flags(l << 5)
+    return nil
+}
+
+// Getter of QoS level -1, i.e. publishing without connecting
+func (f Flags) QosMinusOne() bool {
+    return (f & 0x60) == 0x60
+}
+
+// Setter of QoS level -1
+func (f *Flags) SetQosMinusOne() {
+    *f = *f | 0x60
+}
+
+// Getter of Retain flag
+func (f Flags) Retain() bool {
+    return (f & 0x10) != 0
+}
+
+// Setter of Retain flag
+func (f *Flags) SetRetain(v bool) {
+    f.set(v, 0x10)
+}
+
+// Getter of Will flag
+func (f Flags) Will() bool {
+    return (f & 0x08) != 0
+}
+
+// Setter of Will flag
+func (f *Flags) SetWill(v bool) {
+    f.set(v, 0x08)
+}
+
+// Getter of Clean Session flag
+func (f Flags) CleanSession() bool {
+    return (f & 0x04) != 0
+}
+
+// Setter of Clean Session flag
+func (f *Flags) SetCleanSession(v bool) {
+    f.set(v, 0x04)
+}
+
+// Getter of Topic Id Type
+func (f Flags) TopicIdType() TopicIdType {
+    return TopicIdType(f & 0x03)
+}
+
+// Setter of Topic Id Type
+func (f *Flags) SetTopicIdType(t TopicIdType) {
+    *f = (*f &^ 0x03) | Flags(t & 0x03)
+}
+
+func (f *Flags) set(v bool, mask Flags) {
+    *f = *f &^ mask
+    if v {
+        *f = *f | mask
+    }
+}
+
+// Read a Msg from an io.Reader
+func Read(r io.Reader) (Msg, error) {
+    l, err := readUint8(r)
+    if err != nil {
+        return nil, err
+    }
+    length, hlen := int(l), 2
+    if l == 0x01 {
+        l16, err := readUint16(r)
+        if err != nil {
+            return nil, err
+        }
+        length, hlen = int(l16), 4
+    }
+    if length < hlen {
+        return nil, ErrBadLength
+    }
+    t, err := readUint8(r)
+    if err != nil {
+        return nil, err
+    }
+    f, ok := msgRegistry[MsgType(t)]
+    if !ok {
+        return nil, ErrBadMsgType
+    }
+    msg := f()
+    if err := msg.readFrom(r, length - hlen); err != nil {
+        return nil, err
+    }
+    return msg, nil
+}
+
+// Write a Msg to io.Writer
+func Write(w io.Writer, m Msg) error {
+    p, err := m.body()
+    if err != nil {
+        return err
+    }
+    var h []byte
+    if l := len(p) + 2; l <= 0xff {
+        h = []byte{byte(l), byte(m.Type())}
+    } else if l += 2; l <= 0xffff {
+        h = []byte{0x01, byte(l >> 8), byte(l), byte(m.Type())}
+    } else {
+        return ErrBadLength
+    }
+    if _, err := w.Write(h); err != nil {
+        return err
+    }
+    _, err = w.Write(p)
+    return err
+}
+
+// Read uint8 from io.Reader
+func readUint8(r io.Reader) (uint8, error) {
+    var buf [1]byte
+    if _, err := io.ReadFull(r, buf[:]); err != nil {
+        return 0, err
+    }
+    return buf[0], nil
+}
+
+// Read uint16 from io.Reader
+func readUint16(r io.Reader) (uint16, error) {
+    var buf [2]byte
+    if _, err := io.ReadFull(r, buf[:]); err != nil {
+        return 0, err
+    }
+    return (uint16(buf[0]) << 8) | uint16(buf[1]), nil
+}
+
+// Read the rest of a message, which has no length prefix in MQTT-SN
+func readRest(r *io.LimitedReader) ([]byte, error) {
+    p := make([]byte, r.N)
+    if _, err := io.ReadFull(r, p); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// Append uint16 to p
+func appendUint16(p []byte, val uint16) []byte {
+    return append(p, byte(val >> 8), byte(val & 0x00ff))
+}
@@ -108,14 +108,23 @@ func (m *MsgConnect) writeTo(w io.Writer) error {
         return err
     } else if err := str(m.ClientId).writeTo(b); err != nil {
         return err
-    } else if err := str(m.WillTopic).writeTo(b); err != nil {
-        return err
-    } else if err := str(m.WillMsg).writeTo(b); err != nil {
-        return err
-    } else if err := str(m.UserName).writeTo(b); err != nil {
-        return err
-    } else if err := str(m.Password).writeTo(b); err != nil {
-        return err
+    }
+    if m.WillFlag() {
+        if err := str(m.WillTopic).writeTo(b); err != nil {
+            return err
+        } else if err := str(m.WillMsg).writeTo(b); err != nil {
+            return err
+        }
+    }
+    if m.UserNameFlag() {
+        if err := str(m.UserName).writeTo(b); err != nil {
+            return err
+        }
+    }
+    if m.PasswordFlag() {
+        if err := str(m.Password).writeTo(b); err != nil {
+            return err
+        }
     }
     return writeMsgData(w, m.H, b.Bytes())
 }
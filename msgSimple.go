@@ -39,6 +39,14 @@ func (m *msgSimpleAck) MsgHeader() *Header {
     return &(m.H)
 }
 
+func (m *msgSimpleAck) Id() uint16 {
+    return m.MsgId
+}
+
+func (m *msgSimpleAck) SetId(id uint16) {
+    m.MsgId = id
+}
+
 func (m *msgSimpleAck) readFrom(r io.Reader, h Header, length uint32) error {
     m.H = h
     var err error
@@ -101,6 +101,14 @@ func (m *MsgSubAck) MsgHeader() *Header {
     return &(m.H)
 }
 
+func (m *MsgSubAck) Id() uint16 {
+    return m.MsgId
+}
+
+func (m *MsgSubAck) SetId(id uint16) {
+    m.MsgId = id
+}
+
 func (m *MsgSubAck) readFrom(r io.Reader, h Header, length uint32) error {
     m.H = h
     var err error
@@ -138,6 +146,14 @@ func (m *MsgUnsubscribe) MsgHeader() *Header {
     return &(m.H)
 }
 
+func (m *MsgUnsubscribe) Id() uint16 {
+    return m.MsgId
+}
+
+func (m *MsgUnsubscribe) SetId(id uint16) {
+    m.MsgId = id
+}
+
 func (m *MsgUnsubscribe) readFrom(r io.Reader, h Header, length uint32) error {
     m.H = h
     var err error
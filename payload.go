@@ -0,0 +1,100 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements typed payloads for MsgPublish.Content.
+// Codecs are picked by matching the topic against the filters they are
+// registered with, JSON is used when nothing matches.
+package mqttgo
+
+import (
+    "sync"
+    "encoding/json"
+    )
+
+// Encodes/decodes values to/from MsgPublish.Content
+type Codec interface {
+    Marshal(v interface{}) ([]byte, error)
+    Unmarshal(p []byte, v interface{}) error
+}
+
+// Codec using encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+    return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(p []byte, v interface{}) error {
+    return json.Unmarshal(p, v)
+}
+
+type codecEntry struct {
+    filter  string
+    codec   Codec
+}
+
+// Registered codecs, in the order of registration
+var codecRegistry struct {
+    sync.RWMutex
+    entries []codecEntry
+}
+
+// Registers c for topics matching filter, the first registered match wins
+func RegisterCodec(filter string, c Codec) error {
+    if !ValidTopicFilter(filter) {
+        return ErrBadTopicFilter
+    }
+    codecRegistry.Lock()
+    defer codecRegistry.Unlock()
+    codecRegistry.entries = append(codecRegistry.entries, codecEntry{filter, c})
+    return nil
+}
+
+// Returns the codec registered for topic
+func CodecFor(topic string) Codec {
+    codecRegistry.RLock()
+    defer codecRegistry.RUnlock()
+    for _, e := range codecRegistry.entries {
+        if MatchTopic(e.filter, topic) {
+            return e.codec
+        }
+    }
+    return JSONCodec{}
+}
+
+// Creates a QosAtMostOnce MsgPublish with v encoded by the codec of topic
+func PublishValue(topic string, v interface{}) (*MsgPublish, error) {
+    p, err := CodecFor(topic).Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    return NewPub(topic, QosAtMostOnce, p), nil
+}
+
+// Creates a QosAtMostOnce MsgPublish with v encoded as JSON
+func PublishJSON(topic string, v interface{}) (*MsgPublish, error) {
+    p, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    return NewPub(topic, QosAtMostOnce, p), nil
+}
+
+// Decodes the content of m with the codec of its topic
+func Decode[T any](m *MsgPublish) (T, error) {
+    var v T
+    err := CodecFor(m.Topic).Unmarshal(m.Content, &v)
+    return v, err
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements bounded queues of the messages a broker keeps for
+// a client with a persistent session (CleanSession false) while it is
+// disconnected. A queue is limited by the number of messages and by their
+// total size, the remaining length of each MsgPublish. When a limit would
+// be exceeded the policy decides what is discarded:
+// - QueueDropOldest discards queued messages from the front
+// - QueueDropNewest discards the message being queued
+// - QueueDisconnect discards the whole queue, and the broker is expected
+//   to end the session
+package mqttgo
+
+import (
+    "sync"
+    "errors"
+    )
+
+type QueuePolicy int
+
+const (
+    QueueDropOldest QueuePolicy = iota
+    QueueDropNewest
+    QueueDisconnect
+    )
+
+var ErrQueueOverflow = errors.New("mqttgo/queue: Queue overflow")
+
+// Limits and policy shared by the queues of all clients
+type QueueConfig struct {
+    MaxMsgs     int                 // Zero for no limit
+    MaxBytes    int                 // Zero for no limit
+    Policy      QueuePolicy
+    SkipQos0    bool                // Never queue QoS 0 messages
+    // Called for every discarded message, could be nil.
+    // Must not call methods of the queue.
+    OnDrop      func(clientId string, m *MsgPublish)
+}
+
+// The queue of one client, safe for concurrent use
+type Queue struct {
+    clientId    string
+    cfg         *QueueConfig
+    mu          sync.Mutex
+    msgs        []*MsgPublish
+    bytes       int
+    dropped     uint64
+}
+
+func NewQueue(clientId string, cfg *QueueConfig) *Queue {
+    return &Queue{clientId: clientId, cfg: cfg}
+}
+
+// Adds m to the end of the queue, discarding messages per the policy if
+// a limit is exceeded. Returns ErrQueueOverflow if the policy is
+// QueueDisconnect and the queue was discarded.
+func (q *Queue) Push(m *MsgPublish) error {
+    if qos, _ := m.H.Qos(); qos == QosAtMostOnce && q.cfg.SkipQos0 {
+        return nil
+    }
+    var drops []*MsgPublish
+    var err error
+    n := publishLen(m)
+    q.mu.Lock()
+    if !q.fits(len(q.msgs) + 1, q.bytes + n) {
+        switch q.cfg.Policy {
+        case QueueDropOldest:
+            if !q.fits(1, n) {
+                // Larger than the limit on its own
+                drops = append(drops, m)
+                m = nil
+                break
+            }
+            for !q.fits(len(q.msgs) + 1, q.bytes + n) {
+                drops = append(drops, q.pop())
+            }
+        case QueueDropNewest:
+            drops = append(drops, m)
+            m = nil
+        case QueueDisconnect:
+            drops = append(q.msgs, m)
+            q.msgs, q.bytes, m = nil, 0, nil
+            err = ErrQueueOverflow
+        }
+    }
+    if m != nil {
+        q.msgs = append(q.msgs, m)
+        q.bytes += n
+    }
+    q.dropped += uint64(len(drops))
+    q.mu.Unlock()
+    if q.cfg.OnDrop != nil {
+        for _, d := range drops {
+            q.cfg.OnDrop(q.clientId, d)
+        }
+    }
+    return err
+}
+
+// Removes and returns the oldest message, nil if the queue is empty
+func (q *Queue) Pop() *MsgPublish {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.msgs) == 0 {
+        return nil
+    }
+    return q.pop()
+}
+
+// Removes and returns all messages, oldest first
+func (q *Queue) Drain() []*MsgPublish {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    msgs := q.msgs
+    q.msgs, q.bytes = nil, 0
+    return msgs
+}
+
+// Returns the number of queued messages and their total size
+func (q *Queue) Len() (msgs int, bytes int) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.msgs), q.bytes
+}
+
+// Returns the number of messages discarded so far
+func (q *Queue) Dropped() uint64 {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.dropped
+}
+
+// q.mu must be held and the queue not empty
+func (q *Queue) pop() *MsgPublish {
+    m := q.msgs[0]
+    q.msgs[0] = nil
+    q.msgs = q.msgs[1:]
+    q.bytes -= publishLen(m)
+    return m
+}
+
+func (q *Queue) fits(msgs int, bytes int) bool {
+    return (q.cfg.MaxMsgs == 0 || msgs <= q.cfg.MaxMsgs) &&
+        (q.cfg.MaxBytes == 0 || bytes <= q.cfg.MaxBytes)
+}
+
+// Returns the remaining length of m
+func publishLen(m *MsgPublish) int {
+    n := 2 + len(m.Topic) + len(m.Content)
+    if qos, _ := m.H.Qos(); qos >= QosAtLeastOnce {
+        n += 2
+    }
+    return n
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttgo
+
+import (
+    "testing"
+    )
+
+// A queue recording its drops, limited to 3 messages
+func testQueue(p QueuePolicy) (*Queue, *[]string) {
+    var drops []string
+    cfg := &QueueConfig{MaxMsgs: 3, Policy: p, OnDrop: func(id string, m *MsgPublish) {
+        drops = append(drops, id + ":" + string(m.Content))
+    }}
+    return NewQueue("c1", cfg), &drops
+}
+
+func pushAll(q *Queue, contents ...string) error {
+    var err error
+    for _, c := range contents {
+        err = q.Push(NewPub("a/b", QosAtLeastOnce, []byte(c)))
+    }
+    return err
+}
+
+func queued(q *Queue) string {
+    s := ""
+    for _, m := range q.Drain() {
+        s += string(m.Content)
+    }
+    return s
+}
+
+func TestQueuePolicies(t *testing.T) {
+    for _, c := range []struct {
+        p       QueuePolicy
+        err     error
+        queued  string
+        drops   []string
+    }{
+        {QueueDropOldest, nil, "345", []string{"c1:1", "c1:2"}},
+        {QueueDropNewest, nil, "123", []string{"c1:4", "c1:5"}},
+        {QueueDisconnect, ErrQueueOverflow, "", []string{"c1:1", "c1:2", "c1:3", "c1:4"}},
+    } {
+        q, drops := testQueue(c.p)
+        // 4 overflows, QueueDisconnect discards all of 1 to 4
+        if err := pushAll(q, "1", "2", "3", "4"); err != c.err {
+            t.Errorf("policy %d: Push returned %v, want %v", c.p, err, c.err)
+        }
+        if c.p == QueueDisconnect {
+            q.Drain()
+        } else {
+            pushAll(q, "5")
+        }
+        if got := queued(q); got != c.queued {
+            t.Errorf("policy %d: queued %q, want %q", c.p, got, c.queued)
+        }
+        if len(*drops) != len(c.drops) || uint64(len(c.drops)) != q.Dropped() {
+            t.Fatalf("policy %d: dropped %v (count %d), want %v", c.p, *drops, q.Dropped(), c.drops)
+        }
+        for i := range c.drops {
+            if (*drops)[i] != c.drops[i] {
+                t.Errorf("policy %d: dropped %v, want %v", c.p, *drops, c.drops)
+                break
+            }
+        }
+    }
+}
+
+func TestQueueBytes(t *testing.T) {
+    // Each message has remaining length 2 + 3 + 2 + 10 = 17
+    q := NewQueue("c1", &QueueConfig{MaxBytes: 40})
+    content := make([]byte, 10)
+    for i := 0; i < 3; i++ {
+        q.Push(NewPub("a/b", QosAtLeastOnce, content))
+    }
+    if n, b := q.Len(); n != 2 || b != 34 || q.Dropped() != 1 {
+        t.Errorf("queued %d messages of %d bytes, dropped %d, want 2, 34, 1", n, b, q.Dropped())
+    }
+    // Larger than the limit on its own
+    q.Push(NewPub("a/b", QosAtLeastOnce, make([]byte, 50)))
+    if n, _ := q.Len(); n != 2 || q.Dropped() != 2 {
+        t.Errorf("queued %d messages, dropped %d, want 2, 2", n, q.Dropped())
+    }
+    if q.Pop() == nil || q.Pop() == nil || q.Pop() != nil {
+        t.Error("Pop didn't return the 2 messages")
+    }
+    if n, b := q.Len(); n != 0 || b != 0 {
+        t.Errorf("empty queue has %d messages of %d bytes", n, b)
+    }
+}
+
+func TestQueueSkipQos0(t *testing.T) {
+    q := NewQueue("c1", &QueueConfig{SkipQos0: true})
+    q.Push(NewPub("a/b", QosAtMostOnce, nil))
+    q.Push(NewPub("a/b", QosAtLeastOnce, nil))
+    if n, _ := q.Len(); n != 1 || q.Dropped() != 0 {
+        t.Errorf("queued %d messages, dropped %d, want 1, 0", n, q.Dropped())
+    }
+}
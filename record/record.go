@@ -0,0 +1,180 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package record implements recording the packet stream of a connection
+// and replaying it against a broker or a client.
+//
+// File format:
+// |------------------------------------------------------------------|
+// |                Magic "MQTTREC1", 8 bytes                         |
+// |------------------------------------------------------------------|
+// | Entry: byte1   | Direction                                       |
+// |        byte2-9 | Time since the recording started, nanoseconds   |
+// |        byte10+ | The message in MQTT wire encoding               |
+// |------------------------------------------------------------------|
+// |                More entries ...                                  |
+// |------------------------------------------------------------------|
+package record
+
+import (
+    "io"
+    "sync"
+    "time"
+    "bufio"
+    "errors"
+    "encoding/binary"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+const Magic = "MQTTREC1"
+
+const (
+    ClientToServer Direction = iota
+    ServerToClient
+    )
+
+var ErrBadFormat = errors.New("mqttgo/record: Bad recording format")
+
+// The direction a message was sent in
+type Direction uint8
+
+// The other direction
+func (d Direction) Reverse() Direction {
+    return d ^ 1
+}
+
+// A recorded message
+type Entry struct {
+    Dir     Direction
+    Time    time.Duration   // Since the recording started
+    Msg     mqttgo.Msg
+}
+
+// Writes a recording, safe for concurrent use
+type Writer struct {
+    mu      sync.Mutex
+    w       *bufio.Writer
+    start   time.Time
+}
+
+// Creates a Writer and writes the file header
+func NewWriter(w io.Writer) (*Writer, error) {
+    bw := bufio.NewWriter(w)
+    if _, err := bw.WriteString(Magic); err != nil {
+        return nil, err
+    }
+    return &Writer{w: bw, start: time.Now()}, nil
+}
+
+// Records m sent in direction dir
+func (w *Writer) Record(dir Direction, m mqttgo.Msg) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    var h [9]byte
+    h[0] = byte(dir)
+    binary.BigEndian.PutUint64(h[1:], uint64(time.Since(w.start)))
+    if _, err := w.w.Write(h[:]); err != nil {
+        return err
+    }
+    return mqttgo.Write(w.w, m)
+}
+
+// Flushes buffered entries to the underlying io.Writer
+func (w *Writer) Flush() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.w.Flush()
+}
+
+// Reads a recording
+type Reader struct {
+    r   *bufio.Reader
+}
+
+// Creates a Reader and checks the file header
+func NewReader(r io.Reader) (*Reader, error) {
+    br := bufio.NewReader(r)
+    var magic [len(Magic)]byte
+    if _, err := io.ReadFull(br, magic[:]); err != nil {
+        return nil, err
+    } else if string(magic[:]) != Magic {
+        return nil, ErrBadFormat
+    }
+    return &Reader{br}, nil
+}
+
+// Reads the next entry, returns io.EOF at the end of the recording
+func (r *Reader) Next() (*Entry, error) {
+    var h [9]byte
+    if _, err := io.ReadFull(r.r, h[:]); err == io.ErrUnexpectedEOF {
+        return nil, ErrBadFormat
+    } else if err != nil {
+        return nil, err
+    }
+    if Direction(h[0]) > ServerToClient {
+        return nil, ErrBadFormat
+    }
+    m, err := mqttgo.Read(r.r)
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        return nil, ErrBadFormat
+    } else if err != nil {
+        return nil, err
+    }
+    return &Entry{Direction(h[0]), time.Duration(binary.BigEndian.Uint64(h[1:])), m}, nil
+}
+
+// Reads all remaining entries
+func (r *Reader) All() ([]*Entry, error) {
+    var entries []*Entry
+    for {
+        e, err := r.Next()
+        if err == io.EOF {
+            return entries, nil
+        } else if err != nil {
+            return entries, err
+        }
+        entries = append(entries, e)
+    }
+}
+
+// A connection whose messages are recorded
+type Conn struct {
+    rw  io.ReadWriter
+    rec *Writer
+    out Direction   // Direction of the messages written
+}
+
+// Wraps rw, messages written are recorded in direction out,
+// and messages read in the reverse direction
+func NewConn(rw io.ReadWriter, rec *Writer, out Direction) *Conn {
+    return &Conn{rw, rec, out}
+}
+
+// Reads and records a message
+func (c *Conn) Read() (mqttgo.Msg, error) {
+    m, err := mqttgo.Read(c.rw)
+    if err != nil {
+        return nil, err
+    }
+    return m, c.rec.Record(c.out.Reverse(), m)
+}
+
+// Writes and records a message
+func (c *Conn) Write(m mqttgo.Msg) error {
+    if err := mqttgo.Write(c.rw, m); err != nil {
+        return err
+    }
+    return c.rec.Record(c.out, m)
+}
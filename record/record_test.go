@@ -0,0 +1,173 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package record
+
+import (
+    "net"
+    "time"
+    "bytes"
+    "testing"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Serves one side of a pipe, answering CONNECT and PUBLISH. ackId is
+// the id of PUBACK, zero for the id of the PUBLISH, negative for none.
+func fakeBroker(c net.Conn, ackId int) {
+    defer c.Close()
+    for {
+        m, err := mqttgo.Read(c)
+        if err != nil {
+            return
+        }
+        switch m := m.(type) {
+        case *mqttgo.MsgConnect:
+            mqttgo.Write(c, mqttgo.NewConnAck(mqttgo.RCAccepted))
+        case *mqttgo.MsgPublish:
+            if ackId == 0 {
+                mqttgo.Write(c, mqttgo.NewPubAck(m.MsgId))
+            } else if ackId > 0 {
+                mqttgo.Write(c, mqttgo.NewPubAck(uint16(ackId)))
+            }
+        }
+    }
+}
+
+// Records a client connecting and publishing once
+func record(t *testing.T) []byte {
+    var buf bytes.Buffer
+    w, err := NewWriter(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    client, broker := net.Pipe()
+    defer client.Close()
+    go fakeBroker(broker, 0)
+    c := NewConn(client, w, ClientToServer)
+    pub := mqttgo.NewPub("a/b", mqttgo.QosAtLeastOnce, []byte("hello"))
+    pub.MsgId = 1
+    for _, m := range []mqttgo.Msg{mqttgo.NewConnect("rec", 0), pub} {
+        if err := c.Write(m); err != nil {
+            t.Fatal(err)
+        } else if _, err := c.Read(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func load(t *testing.T, p []byte) []*Entry {
+    r, err := NewReader(bytes.NewReader(p))
+    if err != nil {
+        t.Fatal(err)
+    }
+    entries, err := r.All()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return entries
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+    entries := load(t, record(t))
+    want := []struct {
+        dir Direction
+        t   mqttgo.MsgType
+    }{
+        {ClientToServer, mqttgo.MsgTypeConnect},
+        {ServerToClient, mqttgo.MsgTypeConnAck},
+        {ClientToServer, mqttgo.MsgTypePublish},
+        {ServerToClient, mqttgo.MsgTypePubAck},
+    }
+    if len(entries) != len(want) {
+        t.Fatalf("%d entries, want %d", len(entries), len(want))
+    }
+    for i, w := range want {
+        e := entries[i]
+        if e.Dir != w.dir || e.Msg.MsgHeader().Type() != w.t {
+            t.Errorf("entry %d is direction %d type %d, want %d %d",
+                i, e.Dir, e.Msg.MsgHeader().Type(), w.dir, w.t)
+        }
+        if i > 0 && e.Time < entries[i - 1].Time {
+            t.Errorf("entry %d recorded before entry %d", i, i - 1)
+        }
+    }
+    if p := entries[2].Msg.(*mqttgo.MsgPublish); p.Topic != "a/b" || string(p.Content) != "hello" {
+        t.Errorf("PUBLISH read back as %+v", p)
+    }
+}
+
+func TestRecordBadFormat(t *testing.T) {
+    if _, err := NewReader(bytes.NewReader([]byte("MQTTREC0"))); err != ErrBadFormat {
+        t.Errorf("bad magic: got %v", err)
+    }
+    p := record(t)
+    r, _ := NewReader(bytes.NewReader(p[:len(p) - 1]))
+    if _, err := r.All(); err != ErrBadFormat {
+        t.Errorf("truncated: got %v", err)
+    }
+}
+
+func replay(t *testing.T, entries []*Entry, ackId int, opts ReplayOptions) []Diff {
+    client, broker := net.Pipe()
+    defer client.Close()
+    go fakeBroker(broker, ackId)
+    diffs, err := Replay(client, entries, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return diffs
+}
+
+func TestReplay(t *testing.T) {
+    entries := load(t, record(t))
+    if diffs := replay(t, entries, 0, ReplayOptions{}); len(diffs) != 0 {
+        t.Errorf("same broker: %d diffs", len(diffs))
+    }
+
+    // PUBACK with another id
+    if diffs := replay(t, entries, 9, ReplayOptions{IgnoreIds: true}); len(diffs) != 0 {
+        t.Errorf("IgnoreIds: %d diffs", len(diffs))
+    }
+    diffs := replay(t, entries, 9, ReplayOptions{})
+    if len(diffs) != 1 || diffs[0].Index != 3 || diffs[0].Got.(mqttgo.MsgWithId).Id() != 9 {
+        t.Errorf("got diffs %+v, want PUBACK 9 at 3", diffs)
+    }
+
+    // No PUBACK
+    start := time.Now()
+    diffs = replay(t, entries, -1, ReplayOptions{Timeout: 100 * time.Millisecond})
+    if len(diffs) != 1 || diffs[0].Index != 3 || diffs[0].Got != nil {
+        t.Errorf("got diffs %+v, want a missing PUBACK at 3", diffs)
+    }
+    if d := time.Since(start); d > time.Second {
+        t.Errorf("replay took %v with a timeout of 100ms", d)
+    }
+
+    // The broker closes the connection
+    client, broker := net.Pipe()
+    defer client.Close()
+    go func() {
+        mqttgo.Read(broker)
+        broker.Close()
+    }()
+    diffs, err := Replay(client, entries, ReplayOptions{})
+    if err != nil || len(diffs) != 1 || diffs[0].Index != 1 || diffs[0].Got != nil {
+        t.Errorf("got diffs %+v, %v, want a missing CONNACK at 1", diffs, err)
+    }
+}
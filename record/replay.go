@@ -0,0 +1,120 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements replaying a recording, playing one side of the
+// connection and diffing what the other side sends against the recording.
+package record
+
+import (
+    "io"
+    "os"
+    "net"
+    "time"
+    "bytes"
+    "bufio"
+    "errors"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Default time to wait for an expected message
+const DefaultReplayTimeout = 2 * time.Second
+
+type ReplayOptions struct {
+    // Which side to play, e.g. ClientToServer to replay against a broker
+    Side        Direction
+    // Keep the original timing, otherwise send as fast as possible
+    Realtime    bool
+    // Don't report differences in message ids
+    IgnoreIds   bool
+    // Time to wait for each expected message, DefaultReplayTimeout if zero
+    Timeout     time.Duration
+}
+
+// A received message that differs from the recording
+type Diff struct {
+    Index   int         // Index of the entry
+    Want    mqttgo.Msg
+    Got     mqttgo.Msg  // nil if it didn't arrive in time or the connection ended
+}
+
+// Replays entries over conn, returns the differences found.
+// Messages of opts.Side are sent, the others are expected from conn.
+func Replay(conn net.Conn, entries []*Entry, opts ReplayOptions) ([]Diff, error) {
+    var diffs []Diff
+    timeout := opts.Timeout
+    if timeout <= 0 {
+        timeout = DefaultReplayTimeout
+    }
+    defer conn.SetReadDeadline(time.Time{})
+    r := bufio.NewReader(conn)
+    start := time.Now()
+    for i, e := range entries {
+        if e.Dir == opts.Side {
+            if opts.Realtime {
+                time.Sleep(time.Until(start.Add(e.Time)))
+            }
+            if err := mqttgo.Write(conn, e.Msg); err != nil {
+                return diffs, err
+            }
+            continue
+        }
+        // Waits for the first byte, so a timeout never splits a message
+        conn.SetReadDeadline(time.Now().Add(timeout))
+        if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+            diffs = append(diffs, Diff{i, e.Msg, nil})
+            return diffs, nil
+        } else if errors.Is(err, os.ErrDeadlineExceeded) {
+            diffs = append(diffs, Diff{i, e.Msg, nil})
+            continue
+        } else if err != nil {
+            return diffs, err
+        }
+        conn.SetReadDeadline(time.Now().Add(timeout))
+        got, err := mqttgo.Read(r)
+        if err != nil {
+            return diffs, err
+        }
+        if same, err := equal(e.Msg, got, opts.IgnoreIds); err != nil {
+            return diffs, err
+        } else if !same {
+            diffs = append(diffs, Diff{i, e.Msg, got})
+        }
+    }
+    return diffs, nil
+}
+
+// Compares the wire encoding of two messages
+func equal(a mqttgo.Msg, b mqttgo.Msg, ignoreIds bool) (bool, error) {
+    pa, err := encode(a, ignoreIds)
+    if err != nil {
+        return false, err
+    }
+    pb, err := encode(b, ignoreIds)
+    if err != nil {
+        return false, err
+    }
+    return bytes.Equal(pa, pb), nil
+}
+
+func encode(m mqttgo.Msg, ignoreId bool) ([]byte, error) {
+    if mi, ok := m.(mqttgo.MsgWithId); ok && ignoreId {
+        id := mi.Id()
+        mi.SetId(0)
+        defer mi.SetId(id)
+    }
+    var b bytes.Buffer
+    err := mqttgo.Write(&b, m)
+    return b.Bytes(), err
+}
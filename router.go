@@ -0,0 +1,139 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements a router dispatching MsgPublish to handlers by
+// topic patterns with named parameters:
+// - "{name}" matches one level, like '+'
+// - "#name" matches the remaining levels, like '#', must be the last level
+// e.g. "devices/{deviceID}/telemetry" or "logs/#rest"
+package mqttgo
+
+import (
+    "log"
+    "strings"
+    )
+
+// Parameters extracted from the topic, by name
+type Params map[string]string
+
+// Handles a MsgPublish routed by Router
+type Handler func(m *MsgPublish, p Params)
+
+// Wraps a Handler, e.g. for recovery or logging
+type Middleware func(Handler) Handler
+
+type route struct {
+    filter  string
+    names   []string    // Parameter name of each level, "" if none
+    h       Handler
+}
+
+// Dispatches MsgPublish to the first handler with a matching pattern
+type Router struct {
+    routes      []route
+    middleware  []Middleware
+}
+
+func NewRouter() *Router {
+    return &Router{}
+}
+
+// Registers h for pattern, returns the topic filter to subscribe to
+func (r *Router) Handle(pattern string, h Handler) (string, error) {
+    levels := strings.Split(pattern, TopicSeparator)
+    names := make([]string, len(levels))
+    for i, l := range levels {
+        if len(l) > 2 && l[0] == '{' && l[len(l) - 1] == '}' {
+            names[i], levels[i] = l[1:len(l) - 1], TopicSingleLevel
+        } else if len(l) > 1 && l[0] == '#' {
+            names[i], levels[i] = l[1:], TopicMultiLevel
+        }
+    }
+    filter := strings.Join(levels, TopicSeparator)
+    if !ValidTopicFilter(filter) {
+        return "", ErrBadTopicFilter
+    }
+    r.routes = append(r.routes, route{filter, names, h})
+    return filter, nil
+}
+
+// Adds middleware, applied to all handlers in the order added
+func (r *Router) Use(mw ...Middleware) {
+    r.middleware = append(r.middleware, mw...)
+}
+
+// Returns the topic filters of all registered patterns
+func (r *Router) Filters() []string {
+    filters := make([]string, len(r.routes))
+    for i, rt := range r.routes {
+        filters[i] = rt.filter
+    }
+    return filters
+}
+
+// Routes m to its handler, returns false if no pattern matches
+func (r *Router) Dispatch(m *MsgPublish) bool {
+    for _, rt := range r.routes {
+        if !MatchTopic(rt.filter, m.Topic) {
+            continue
+        }
+        h := rt.h
+        for i := len(r.middleware) - 1; i >= 0; i-- {
+            h = r.middleware[i](h)
+        }
+        h(m, rt.params(m.Topic))
+        return true
+    }
+    return false
+}
+
+// Extracts the named parameters from topic, which matches rt.filter
+func (rt *route) params(topic string) Params {
+    p := make(Params)
+    levels := strings.Split(topic, TopicSeparator)
+    last := len(rt.names) - 1
+    for i, name := range rt.names {
+        if name == "" {
+            continue
+        } else if i < last || !strings.HasSuffix(rt.filter, TopicMultiLevel) {
+            p[name] = levels[i]
+        } else if i < len(levels) {
+            p[name] = strings.Join(levels[i:], TopicSeparator)
+        } else {
+            p[name] = "" // "#" matching the parent level
+        }
+    }
+    return p
+}
+
+// Middleware recovering from panics in handlers, and logging them
+func Recover(next Handler) Handler {
+    return func(m *MsgPublish, p Params) {
+        defer func() {
+            if e := recover(); e != nil {
+                log.Printf("mqttgo/router: panic handling %s: %v", m.Topic, e)
+            }
+        }()
+        next(m, p)
+    }
+}
+
+// Middleware logging every routed message
+func Logger(next Handler) Handler {
+    return func(m *MsgPublish, p Params) {
+        log.Printf("ROUTE topic: %s, len %d", m.Topic, len(m.Content))
+        next(m, p)
+    }
+}
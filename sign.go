@@ -0,0 +1,194 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements signing MsgPublish.Content with Ed25519.
+//
+// Envelope format:
+// |------------------------------------------------------------------|
+// | byte1          | Version                                         |
+// | byte2          | Length of key id                                |
+// | byte3+         | Key id                                          |
+// |------------------------------------------------------------------|
+// |                Timestamp, unix nanoseconds, 8 bytes              |
+// |                Nonce, 16 bytes                                   |
+// |------------------------------------------------------------------|
+// |                Content                                           |
+// |------------------------------------------------------------------|
+// |                Signature, 64 bytes                               |
+// |------------------------------------------------------------------|
+// The signature covers the topic and everything before the signature.
+package mqttgo
+
+import (
+    "sync"
+    "time"
+    "errors"
+    "container/heap"
+    "crypto/rand"
+    "crypto/ed25519"
+    "encoding/binary"
+    )
+
+const SignedEnvelopeVersion = 1
+
+const signNonceSize = 16
+
+// Default time window in which a signed message is accepted
+const DefaultReplayWindow = 5 * time.Minute
+
+// Errors could happen when verifying payloads
+var (
+    ErrBadSignature = errors.New("mqttgo/sign: Bad signature")
+    ErrStale = errors.New("mqttgo/sign: Timestamp out of replay window")
+    ErrReplay = errors.New("mqttgo/sign: Nonce already seen")
+    ErrBadSignKey = errors.New("mqttgo/sign: Bad Ed25519 key length")
+    )
+
+// Signs MsgPublish.Content
+type Signer struct {
+    KeyId   string
+    Key     ed25519.PrivateKey
+}
+
+// Replaces m.Content with the signed envelope
+func (s *Signer) Sign(m *MsgPublish) error {
+    if len(s.KeyId) == 0 || len(s.KeyId) > 0xff {
+        return ErrBadKeyId
+    } else if len(s.Key) != ed25519.PrivateKeySize {
+        return ErrBadSignKey
+    }
+    p := make([]byte, 0, 2 + len(s.KeyId) + 8 + signNonceSize +
+        len(m.Content) + ed25519.SignatureSize)
+    p = append(p, SignedEnvelopeVersion, byte(len(s.KeyId)))
+    p = append(p, s.KeyId...)
+    p = binary.BigEndian.AppendUint64(p, uint64(time.Now().UnixNano()))
+    n := len(p)
+    p = p[:n + signNonceSize]
+    if _, err := rand.Read(p[n:]); err != nil {
+        return err
+    }
+    p = append(p, m.Content...)
+    m.Content = append(p, ed25519.Sign(s.Key, signedData(m.Topic, p))...)
+    return nil
+}
+
+// Verifies signed envelopes against a set of trusted keys, and rejects
+// messages outside the replay window or with a nonce already seen.
+type Verifier struct {
+    Window  time.Duration
+    mu      sync.Mutex
+    keys    map[string]ed25519.PublicKey
+    seen    map[string]time.Time
+    expiry  nonceHeap   // The nonces in seen, oldest timestamp first
+}
+
+type seenNonce struct {
+    nonce   string
+    ts      time.Time
+}
+
+// A min-heap of nonces by timestamp, implementing heap.Interface
+type nonceHeap []seenNonce
+
+func (h nonceHeap) Len() int { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].ts.Before(h[j].ts) }
+func (h nonceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any) { *h = append(*h, x.(seenNonce)) }
+
+func (h *nonceHeap) Pop() any {
+    old := *h
+    x := old[len(old) - 1]
+    *h = old[:len(old) - 1]
+    return x
+}
+
+// Creates a Verifier, a non-positive window means DefaultReplayWindow
+func NewVerifier(window time.Duration) *Verifier {
+    if window <= 0 {
+        window = DefaultReplayWindow
+    }
+    return &Verifier{
+        Window: window,
+        keys:   make(map[string]ed25519.PublicKey),
+        seen:   make(map[string]time.Time),
+    }
+}
+
+// Trusts key for the key id
+func (v *Verifier) AddKey(keyId string, key ed25519.PublicKey) error {
+    if len(key) != ed25519.PublicKeySize {
+        return ErrBadSignKey
+    }
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    v.keys[keyId] = key
+    return nil
+}
+
+// Stops trusting the key id
+func (v *Verifier) RemoveKey(keyId string) {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    delete(v.keys, keyId)
+}
+
+// Verifies m.Content and replaces it with the content inside the envelope
+func (v *Verifier) Verify(m *MsgPublish) error {
+    p := m.Content
+    if len(p) < 2 || p[0] != SignedEnvelopeVersion {
+        return ErrBadEnvelope
+    }
+    hlen := 2 + int(p[1]) + 8 + signNonceSize
+    if len(p) < hlen + ed25519.SignatureSize {
+        return ErrBadEnvelope
+    }
+    keyId := string(p[2:2 + int(p[1])])
+    ts := time.Unix(0, int64(binary.BigEndian.Uint64(p[hlen - 8 - signNonceSize:])))
+    nonce := string(p[hlen - signNonceSize:hlen])
+    body, sig := p[:len(p) - ed25519.SignatureSize], p[len(p) - ed25519.SignatureSize:]
+
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    key, ok := v.keys[keyId]
+    if !ok {
+        return ErrUnknownKey
+    }
+    if !ed25519.Verify(key, signedData(m.Topic, body), sig) {
+        return ErrBadSignature
+    }
+    now := time.Now()
+    if ts.Before(now.Add(-v.Window)) || ts.After(now.Add(v.Window)) {
+        return ErrStale
+    }
+    // Nonces out of the window can't be replayed, as ErrStale rejects them
+    for len(v.expiry) > 0 && v.expiry[0].ts.Before(now.Add(-v.Window)) {
+        delete(v.seen, heap.Pop(&v.expiry).(seenNonce).nonce)
+    }
+    if _, ok := v.seen[nonce]; ok {
+        return ErrReplay
+    }
+    v.seen[nonce] = ts
+    heap.Push(&v.expiry, seenNonce{nonce, ts})
+    m.Content = body[hlen:]
+    return nil
+}
+
+// The data covered by the signature
+func signedData(topic string, body []byte) []byte {
+    d := make([]byte, 0, 2 + len(topic) + len(body))
+    d = binary.BigEndian.AppendUint16(d, uint16(len(topic)))
+    d = append(d, topic...)
+    return append(d, body...)
+}
@@ -0,0 +1,53 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttgo
+
+import (
+    "testing"
+    "crypto/ed25519"
+    )
+
+func TestSignKeys(t *testing.T) {
+    pub, priv, err := ed25519.GenerateKey(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    m := NewPub("a/b", QosAtMostOnce, []byte("hello"))
+    if err := (&Signer{KeyId: "k1", Key: priv[:3]}).Sign(m); err != ErrBadSignKey {
+        t.Errorf("Sign with a 3 byte key returned %v", err)
+    }
+    v := NewVerifier(0)
+    if err := v.AddKey("k1", pub[:3]); err != ErrBadSignKey {
+        t.Errorf("AddKey with a 3 byte key returned %v", err)
+    }
+    if err := (&Signer{KeyId: "k1", Key: priv}).Sign(m); err != nil {
+        t.Fatal(err)
+    }
+    signed := append([]byte(nil), m.Content...)
+    if err := v.Verify(m); err != ErrUnknownKey {
+        t.Errorf("Verify with no key returned %v", err)
+    }
+    if err := v.AddKey("k1", pub); err != nil {
+        t.Fatal(err)
+    }
+    if err := v.Verify(m); err != nil || string(m.Content) != "hello" {
+        t.Errorf("Verify returned %v, content %q", err, m.Content)
+    }
+    m.Content = signed
+    if err := v.Verify(m); err != ErrReplay {
+        t.Errorf("Verify of a replay returned %v", err)
+    }
+}
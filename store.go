@@ -0,0 +1,280 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements persisting in flight messages of a client,
+// so that QoS 1/2 flows survive a restart.
+// - Outbound MsgPublish is put before it is sent, and deleted on
+//   MsgPubAck (QoS 1), or replaced by MsgPubRel on MsgPubRec (QoS 2)
+//   which is deleted on MsgPubComp
+// - Inbound QoS 2 message ids are put once MsgPubRec is sent,
+//   and deleted on MsgPubRel
+//
+// FileStore file format of outbound messages:
+// |------------------------------------------------------------------|
+// |                Sequence number, 8 bytes                          |
+// |------------------------------------------------------------------|
+// |                The message in MQTT wire encoding                 |
+// |------------------------------------------------------------------|
+// The sequence number gives the order the messages were first put in.
+package mqttgo
+
+import (
+    "os"
+    "io"
+    "sort"
+    "sync"
+    "bufio"
+    "strconv"
+    "strings"
+    "path/filepath"
+    "encoding/binary"
+    )
+
+// Persists in flight messages of a client
+type Store interface {
+    // Saves an outbound message, replacing the one with the same id
+    PutOutbound(m MsgWithId) error
+    // Removes the outbound message with the id
+    DelOutbound(id uint16) error
+    // Records an inbound QoS 2 message id which MsgPubRec was sent for
+    PutReceived(id uint16) error
+    // Removes the inbound QoS 2 message id
+    DelReceived(id uint16) error
+    // Returns the outbound messages in the order they were put, with Dup
+    // set on MsgPublish, and the inbound QoS 2 message ids
+    Load() ([]MsgWithId, []uint16, error)
+}
+
+const (
+    storeOutPrefix  = "out-"
+    storeRecPrefix  = "rec-"
+    storeTmpSuffix  = ".tmp"
+    )
+
+// Store keeping each message in its own file in a directory.
+// Files are written to a temporary file, synced and renamed,
+// so a crash never leaves a partially written message behind.
+type FileStore struct {
+    dir     string
+    mu      sync.Mutex
+    seqs    map[uint16]uint64   // Sequence numbers of outbound messages, by id
+    lastSeq uint64
+}
+
+// Creates a FileStore in dir, which is created if missing
+func NewFileStore(dir string) (*FileStore, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    s := &FileStore{dir: dir, seqs: make(map[uint16]uint64)}
+    outs, _, err := s.scan(true)
+    if err != nil {
+        return nil, err
+    }
+    for id, name := range outs {
+        seq, err := s.readSeq(name)
+        if err != nil {
+            return nil, err
+        }
+        s.seqs[id] = seq
+        if seq > s.lastSeq {
+            s.lastSeq = seq
+        }
+    }
+    return s, nil
+}
+
+// Saves m, a replaced message keeps its place in the order
+func (s *FileStore) PutOutbound(m MsgWithId) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    seq, ok := s.seqs[m.Id()]
+    if !ok {
+        s.lastSeq++
+        seq = s.lastSeq
+    }
+    var h [8]byte
+    binary.BigEndian.PutUint64(h[:], seq)
+    if err := s.write(storeOutPrefix + strconv.Itoa(int(m.Id())), h[:], m); err != nil {
+        return err
+    }
+    s.seqs[m.Id()] = seq
+    return nil
+}
+
+func (s *FileStore) DelOutbound(id uint16) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err := s.remove(storeOutPrefix + strconv.Itoa(int(id))); err != nil {
+        return err
+    }
+    delete(s.seqs, id)
+    return nil
+}
+
+func (s *FileStore) PutReceived(id uint16) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.write(storeRecPrefix + strconv.Itoa(int(id)), nil, nil)
+}
+
+func (s *FileStore) DelReceived(id uint16) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.remove(storeRecPrefix + strconv.Itoa(int(id)))
+}
+
+func (s *FileStore) Load() ([]MsgWithId, []uint16, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    outs, recs, err := s.scan(false)
+    if err != nil {
+        return nil, nil, err
+    }
+    ids := make([]uint16, 0, len(outs))
+    for id := range outs {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return s.seqs[ids[i]] < s.seqs[ids[j]] })
+    msgs := make([]MsgWithId, 0, len(ids))
+    for _, id := range ids {
+        m, err := s.read(outs[id])
+        if err != nil {
+            return nil, nil, err
+        }
+        if m.MsgHeader().Type() == MsgTypePublish {
+            m.MsgHeader().SetDup(true)
+        }
+        msgs = append(msgs, m)
+    }
+    return msgs, recs, nil
+}
+
+// Lists the files of outbound messages by id, and the inbound ids.
+// If clean is true, temporary files left by a crash are removed, which is
+// only safe before the store is used.
+func (s *FileStore) scan(clean bool) (map[uint16]string, []uint16, error) {
+    entries, err := os.ReadDir(s.dir)
+    if err != nil {
+        return nil, nil, err
+    }
+    outs := make(map[uint16]string)
+    var recs []uint16
+    for _, e := range entries {
+        name := e.Name()
+        if strings.HasSuffix(name, storeTmpSuffix) {
+            if clean {
+                os.Remove(filepath.Join(s.dir, name))
+            }
+        } else if strings.HasPrefix(name, storeRecPrefix) {
+            if id, err := strconv.ParseUint(name[len(storeRecPrefix):], 10, 16); err == nil {
+                recs = append(recs, uint16(id))
+            }
+        } else if strings.HasPrefix(name, storeOutPrefix) {
+            if id, err := strconv.ParseUint(name[len(storeOutPrefix):], 10, 16); err == nil {
+                outs[uint16(id)] = name
+            }
+        }
+    }
+    return outs, recs, nil
+}
+
+// Writes header followed by the encoded m to the file name, both could be nil
+func (s *FileStore) write(name string, header []byte, m Msg) error {
+    path := filepath.Join(s.dir, name)
+    f, err := os.Create(path + storeTmpSuffix)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    if _, err = w.Write(header); err == nil && m != nil {
+        err = Write(w, m)
+    }
+    if err == nil {
+        err = w.Flush()
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(path + storeTmpSuffix)
+        return err
+    }
+    if err := os.Rename(path + storeTmpSuffix, path); err != nil {
+        return err
+    }
+    return s.syncDir()
+}
+
+// Reads the sequence number in the file name
+func (s *FileStore) readSeq(name string) (uint64, error) {
+    f, err := os.Open(filepath.Join(s.dir, name))
+    if err != nil {
+        return 0, err
+    }
+    defer f.Close()
+    var h [8]byte
+    if _, err := io.ReadFull(f, h[:]); err == io.EOF {
+        return 0, io.ErrUnexpectedEOF
+    } else if err != nil {
+        return 0, err
+    }
+    return binary.BigEndian.Uint64(h[:]), nil
+}
+
+// Reads the message in the file name, after the sequence number
+func (s *FileStore) read(name string) (MsgWithId, error) {
+    f, err := os.Open(filepath.Join(s.dir, name))
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    r := bufio.NewReader(f)
+    if _, err := r.Discard(8); err == io.EOF {
+        return nil, io.ErrUnexpectedEOF
+    } else if err != nil {
+        return nil, err
+    }
+    m, err := Read(r)
+    if err == io.EOF {
+        return nil, io.ErrUnexpectedEOF
+    } else if err != nil {
+        return nil, err
+    }
+    if mi, ok := m.(MsgWithId); ok {
+        return mi, nil
+    }
+    return nil, ErrBadMsgType
+}
+
+func (s *FileStore) remove(name string) error {
+    if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return s.syncDir()
+}
+
+// Syncs the directory so that renames and removes are durable
+func (s *FileStore) syncDir() error {
+    d, err := os.Open(s.dir)
+    if err != nil {
+        return err
+    }
+    defer d.Close()
+    return d.Sync()
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttgo
+
+import (
+    "os"
+    "time"
+    "testing"
+    "path/filepath"
+    )
+
+// Stops a client partway through its flows, and loads the store again
+func TestFileStoreRestart(t *testing.T) {
+    dir := t.TempDir()
+    s, err := NewFileStore(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    must := func(err error) {
+        t.Helper()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    pub := func(id uint16, qos QosLevel) *MsgPublish {
+        m := NewPub("a/b", qos, []byte{byte(id)})
+        m.MsgId = id
+        return m
+    }
+    must(s.PutOutbound(pub(9, QosExactlyOnce)))
+    must(s.PutOutbound(pub(10, QosAtLeastOnce)))
+    must(s.PutOutbound(pub(11, QosAtLeastOnce)))
+    must(s.PutOutbound(pub(12, QosAtLeastOnce)))
+    must(s.PutOutbound(NewPubRel(9)))   // PUBREC received for 9
+    must(s.DelOutbound(11))             // PUBACK received for 11
+    must(s.PutReceived(5))              // PUBREC sent for inbound 5
+
+    // Killed while writing 13
+    tmp := filepath.Join(dir, storeOutPrefix + "13" + storeTmpSuffix)
+    must(os.WriteFile(tmp, []byte{0, 0, 0}, 0600))
+    // A coarse clock gives all files the same mtime
+    mtime := time.Now().Truncate(time.Second)
+    entries, err := os.ReadDir(dir)
+    must(err)
+    for _, e := range entries {
+        must(os.Chtimes(filepath.Join(dir, e.Name()), mtime, mtime))
+    }
+
+    s, err = NewFileStore(dir)
+    must(err)
+    msgs, recs, err := s.Load()
+    must(err)
+    if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+        t.Errorf("temporary file not removed: %v", err)
+    }
+    if len(recs) != 1 || recs[0] != 5 {
+        t.Errorf("received ids %v, want [5]", recs)
+    }
+    want := []struct {
+        t   MsgType
+        id  uint16
+    }{{MsgTypePubRel, 9}, {MsgTypePublish, 10}, {MsgTypePublish, 12}}
+    if len(msgs) != len(want) {
+        t.Fatalf("loaded %d messages, want %d", len(msgs), len(want))
+    }
+    for i, w := range want {
+        m := msgs[i]
+        if m.MsgHeader().Type() != w.t || m.Id() != w.id {
+            t.Errorf("message %d is type %d id %d, want type %d id %d",
+                i, m.MsgHeader().Type(), m.Id(), w.t, w.id)
+        } else if w.t == MsgTypePublish && !m.MsgHeader().Dup() {
+            t.Errorf("message %d doesn't have Dup set", i)
+        }
+    }
+
+    // Messages put after the restart come last
+    must(s.PutOutbound(pub(1, QosAtLeastOnce)))
+    msgs, _, err = s.Load()
+    must(err)
+    if len(msgs) != 4 || msgs[3].Id() != 1 {
+        t.Errorf("message put after restart isn't last")
+    }
+}
+
+// Puts and deletes while another goroutine loads
+func TestFileStoreConcurrentLoad(t *testing.T) {
+    s, err := NewFileStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan struct{})
+    loaded := make(chan error)
+    go func() {
+        for {
+            select {
+            case <-done:
+                close(loaded)
+                return
+            default:
+            }
+            if _, _, err := s.Load(); err != nil {
+                loaded <- err
+            }
+        }
+    }()
+    for i := 0; i < 300; i++ {
+        id := uint16(i % 10)
+        if err := s.PutReceived(id); err != nil {
+            t.Errorf("PutReceived: %v", err)
+        }
+        if err := s.PutOutbound(NewPubRel(id)); err != nil {
+            t.Errorf("PutOutbound: %v", err)
+        }
+        if i % 3 == 0 {
+            s.DelReceived(id)
+            s.DelOutbound(id)
+        }
+    }
+    close(done)
+    for err := range loaded {
+        t.Errorf("Load: %v", err)
+    }
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements the $SYS topic tree, which brokers use to
+// publish information about themselves as retained messages.
+package mqttgo
+
+import (
+    "strconv"
+    "time"
+    )
+
+// Default interval between two rounds of $SYS publishes
+const DefaultSysInterval = 10 * time.Second
+
+const (
+    SysTopicVersion         = "$SYS/broker/version"
+    SysTopicUptime          = "$SYS/broker/uptime"
+    SysTopicClients         = "$SYS/broker/clients/connected"
+    SysTopicMsgsReceived    = "$SYS/broker/messages/received"
+    SysTopicMsgsSent        = "$SYS/broker/messages/sent"
+    SysTopicRetained        = "$SYS/broker/retained messages/count"
+    )
+
+// Broker statistics published under $SYS/broker
+type SysStats struct {
+    Version         string
+    Uptime          time.Duration
+    Clients         int
+    MsgsReceived    uint64
+    MsgsSent        uint64
+    Retained        int
+}
+
+// Creates the retained MsgPublish messages for the statistics
+func (s *SysStats) Msgs() []*MsgPublish {
+    return []*MsgPublish{
+        newSysPub(SysTopicVersion, s.Version),
+        newSysPub(SysTopicUptime, strconv.FormatInt(int64(s.Uptime / time.Second), 10) + " seconds"),
+        newSysPub(SysTopicClients, strconv.Itoa(s.Clients)),
+        newSysPub(SysTopicMsgsReceived, strconv.FormatUint(s.MsgsReceived, 10)),
+        newSysPub(SysTopicMsgsSent, strconv.FormatUint(s.MsgsSent, 10)),
+        newSysPub(SysTopicRetained, strconv.Itoa(s.Retained)),
+    }
+}
+
+// Calls stats every interval and passes the resulting messages to send,
+// until done is closed. A non-positive interval means DefaultSysInterval.
+func RunSys(interval time.Duration, stats func() *SysStats,
+    send func(*MsgPublish), done <-chan struct{}) {
+    if interval <= 0 {
+        interval = DefaultSysInterval
+    }
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+        select {
+        case <-done:
+            return
+        case <-t.C:
+            for _, m := range stats().Msgs() {
+                send(m)
+            }
+        }
+    }
+}
+
+func newSysPub(topic string, val string) *MsgPublish {
+    m := NewPub(topic, QosAtMostOnce, []byte(val))
+    m.H.SetRetain(true)
+    return m
+}
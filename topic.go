@@ -0,0 +1,190 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// This file implements matching topic names against topic filters,
+// with the wildcards:
+// - '+' matches exactly one topic level
+// - '#' matches any number of levels, must be the last level
+// Topics starting with '$' (e.g. $SYS) are not matched by filters
+// starting with a wildcard.
+// Also implements parsing shared subscriptions, i.e. $share/<group>/<filter>,
+// and rewriting topic prefixes, e.g. mounting the topics of each client
+// under its own prefix.
+package mqttgo
+
+import (
+    "errors"
+    "strings"
+    )
+
+const (
+    TopicSeparator      = "/"
+    TopicSingleLevel    = "+"
+    TopicMultiLevel     = "#"
+    SharePrefix         = "$share/"
+    )
+
+var (
+    ErrBadTopicFilter   = errors.New("mqttgo/topic: Bad topic filter")
+    ErrBadRewriteRule   = errors.New("mqttgo/topic: Rewrite rule must be from=to, to non-empty")
+    )
+
+// Reports whether the topic filter is well formed
+func ValidTopicFilter(filter string) bool {
+    if filter == "" {
+        return false
+    }
+    levels := strings.Split(filter, TopicSeparator)
+    for i, l := range levels {
+        if l == TopicMultiLevel {
+            if i != len(levels) - 1 {
+                return false
+            }
+        } else if l != TopicSingleLevel && strings.ContainsAny(l, "+#") {
+            return false
+        }
+    }
+    return true
+}
+
+// Reports whether the topic name matches the topic filter
+func MatchTopic(filter string, topic string) bool {
+    if filter == "" || topic == "" {
+        return false
+    }
+    fl := strings.Split(filter, TopicSeparator)
+    tl := strings.Split(topic, TopicSeparator)
+    if strings.HasPrefix(topic, "$") &&
+        (fl[0] == TopicSingleLevel || fl[0] == TopicMultiLevel) {
+        return false
+    }
+    for i, f := range fl {
+        if f == TopicMultiLevel {
+            return i == len(fl) - 1
+        } else if i >= len(tl) {
+            return false
+        } else if f != TopicSingleLevel && f != tl[i] {
+            return false
+        }
+    }
+    return len(fl) == len(tl)
+}
+
+// Splits a shared subscription filter into the share name and the
+// topic filter, ok is false if filter is not a valid shared subscription
+func ParseSharedFilter(filter string) (group string, f string, ok bool) {
+    if !strings.HasPrefix(filter, SharePrefix) {
+        return "", "", false
+    }
+    rest := filter[len(SharePrefix):]
+    i := strings.Index(rest, TopicSeparator)
+    if i <= 0 {
+        return "", "", false
+    }
+    group, f = rest[:i], rest[i + 1:]
+    if strings.ContainsAny(group, "+#") || !ValidTopicFilter(f) {
+        return "", "", false
+    }
+    return group, f, true
+}
+
+// Replaces the topic prefix From by To in topics from a client, and To by
+// From in topics delivered to it. "%c" and "%u" in either are replaced by
+// the client id and the username, e.g. {"", "tenants/acme/%c/"} mounts
+// the topics of each client under its own prefix.
+// A rule using "%c" or "%u" never matches if the value contains '/', '+'
+// or '#', so that a client can't escape its prefix.
+type RewriteRule struct {
+    From    string
+    To      string  // Not empty, or every topic would match when reversed
+}
+
+// Rules applied in order, the first matching rule wins
+type RewriteRules []RewriteRule
+
+// Parses a rule written as from=to
+func ParseRewriteRule(s string) (RewriteRule, error) {
+    from, to, ok := strings.Cut(s, "=")
+    if !ok || to == "" {
+        return RewriteRule{}, ErrBadRewriteRule
+    }
+    return RewriteRule{from, to}, nil
+}
+
+// Rewrites a topic from the client, or to it if reverse is true,
+// returns whether a rule matched
+func (rs RewriteRules) Apply(topic string, clientId string, username string, reverse bool) (string, bool) {
+    for _, r := range rs {
+        from, ok1 := expandRewrite(r.From, clientId, username)
+        to, ok2 := expandRewrite(r.To, clientId, username)
+        if !ok1 || !ok2 {
+            continue
+        }
+        if reverse {
+            from, to = to, from
+        }
+        if strings.HasPrefix(topic, from) {
+            return to + topic[len(from):], true
+        }
+    }
+    return topic, false
+}
+
+// Rewrites the topics of MsgPublish, MsgSubscribe and MsgUnsubscribe from
+// the client, or of MsgPublish delivered to it if reverse is true.
+// The filter of a shared subscription is rewritten, not the share name.
+// Returns whether anything changed.
+func (rs RewriteRules) Rewrite(m Msg, clientId string, username string, reverse bool) bool {
+    changed := false
+    apply := func(topic string) string {
+        t, ok := rs.Apply(topic, clientId, username, reverse)
+        changed = changed || ok
+        return t
+    }
+    filter := func(f string) string {
+        if group, f, ok := ParseSharedFilter(f); ok {
+            return SharePrefix + group + TopicSeparator + apply(f)
+        }
+        return apply(f)
+    }
+    switch m := m.(type) {
+    case *MsgPublish:
+        m.Topic = apply(m.Topic)
+    case *MsgSubscribe:
+        if !reverse {
+            for i := range m.Topics {
+                m.Topics[i].Topic = filter(m.Topics[i].Topic)
+            }
+        }
+    case *MsgUnsubscribe:
+        if !reverse {
+            for i := range m.Topics {
+                m.Topics[i] = filter(m.Topics[i])
+            }
+        }
+    }
+    return changed
+}
+
+func expandRewrite(prefix string, clientId string, username string) (string, bool) {
+    if !strings.Contains(prefix, "%") {
+        return prefix, true
+    }
+    if (strings.Contains(prefix, "%c") && strings.ContainsAny(clientId, "/+#")) ||
+        (strings.Contains(prefix, "%u") && strings.ContainsAny(username, "/+#")) {
+        return "", false
+    }
+    return strings.NewReplacer("%c", clientId, "%u", username).Replace(prefix), true
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package mqttgo
+
+import (
+    "testing"
+    )
+
+func TestRewriteRules(t *testing.T) {
+    var rs RewriteRules
+    for _, s := range []string{"legacy/=tenants/acme/old/", "=tenants/acme/%c/"} {
+        r, err := ParseRewriteRule(s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        rs = append(rs, r)
+    }
+    for _, s := range []string{"a/", "a/=", ""} {
+        if _, err := ParseRewriteRule(s); err != ErrBadRewriteRule {
+            t.Errorf("%q: got %v, want ErrBadRewriteRule", s, err)
+        }
+    }
+
+    for _, c := range []struct {
+        topic   string
+        id      string
+        reverse bool
+        want    string
+        ok      bool
+    }{
+        {"dev123/temp", "dev123", false, "tenants/acme/dev123/dev123/temp", true},
+        {"legacy/x", "dev123", false, "tenants/acme/old/x", true},
+        {"tenants/acme/dev123/temp", "dev123", true, "temp", true},
+        {"tenants/acme/other/temp", "dev123", true, "tenants/acme/other/temp", false},
+        // Can't escape the mount point
+        {"temp", "a/#", false, "temp", false},
+    } {
+        got, ok := rs.Apply(c.topic, c.id, "", c.reverse)
+        if got != c.want || ok != c.ok {
+            t.Errorf("Apply(%q, %q, %t) = %q, %t, want %q, %t", c.topic, c.id, c.reverse, got, ok, c.want, c.ok)
+        }
+    }
+
+    sub := NewSubscribe(1, QosAtLeastOnce, "temp/#", SharePrefix + "g/temp")
+    if !rs.Rewrite(sub, "d1", "", false) {
+        t.Error("SUBSCRIBE not rewritten")
+    }
+    if sub.Topics[0].Topic != "tenants/acme/d1/temp/#" || sub.Topics[1].Topic != "$share/g/tenants/acme/d1/temp" {
+        t.Errorf("SUBSCRIBE rewritten to %v", sub.Topics)
+    }
+    pub := NewPub("tenants/acme/u1/d1/temp", QosAtMostOnce, nil)
+    rs = RewriteRules{{"", "tenants/acme/%u/%c/"}}
+    if !rs.Rewrite(pub, "d1", "u1", true) || pub.Topic != "temp" {
+        t.Errorf("PUBLISH delivered as %q", pub.Topic)
+    }
+}
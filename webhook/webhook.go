@@ -0,0 +1,191 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+// Package webhook implements a bridge POSTing MsgPublish to HTTP endpoints
+// by topic filter. The request body is the content, the metadata is sent
+// in the headers X-Mqtt-Topic, X-Mqtt-Qos and X-Mqtt-Retain.
+// Deliveries failing after all attempts are published as JSON to a
+// dead letter topic.
+package webhook
+
+import (
+    "io"
+    "fmt"
+    "sync"
+    "time"
+    "bytes"
+    "strconv"
+    "net/http"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+const (
+    DefaultAttempts = 3
+    DefaultBackoff  = time.Second
+    DefaultTimeout  = 10 * time.Second  // Of each request, with the default client
+    )
+
+// Used when Bridge.Client is nil, unlike http.DefaultClient it has a timeout
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Response bodies up to this size are read, so the connection can be reused
+const maxDrain = 64 * 1024
+
+type webhook struct {
+    filter  string
+    url     string
+}
+
+// A failed delivery, published to the dead letter topic
+type DeadLetter struct {
+    Topic   string          `json:"topic"`
+    Qos     mqttgo.QosLevel `json:"qos"`
+    Retain  bool            `json:"retain"`
+    Content []byte          `json:"content"`
+    URL     string          `json:"url"`
+    Error   string          `json:"error"`
+}
+
+// POSTs MsgPublish to the URLs of all hooks with a matching filter.
+// The zero value makes one attempt per delivery, drops failed deliveries
+// and doesn't limit concurrent requests.
+type Bridge struct {
+    // A client with DefaultTimeout if nil. A client without a timeout lets
+    // an endpoint that never answers hold a request slot forever.
+    Client      *http.Client
+    Attempts    int                 // Tries per delivery
+    Backoff     time.Duration       // Before the first retry, doubles after each
+    DeadLetter  string              // Topic of failed deliveries, "" to drop them
+    hooks       []webhook
+    sem         chan struct{}       // nil for no limit
+    send        func(*mqttgo.MsgPublish)
+    wg          sync.WaitGroup
+}
+
+// Creates a bridge making at most concurrency requests at a time.
+// Dead letters are passed to send, which is called from the delivery
+// goroutines and must be safe for concurrent use.
+func NewBridge(concurrency int, send func(*mqttgo.MsgPublish)) *Bridge {
+    if concurrency < 1 {
+        concurrency = 1
+    }
+    return &Bridge{
+        Attempts: DefaultAttempts,
+        Backoff:  DefaultBackoff,
+        sem:      make(chan struct{}, concurrency),
+        send:     send,
+    }
+}
+
+// Adds a hook POSTing messages matching filter to url
+func (b *Bridge) AddHook(filter string, url string) error {
+    if !mqttgo.ValidTopicFilter(filter) {
+        return mqttgo.ErrBadTopicFilter
+    }
+    b.hooks = append(b.hooks, webhook{filter, url})
+    return nil
+}
+
+// Returns the topic filters of all hooks
+func (b *Bridge) Filters() []string {
+    filters := make([]string, len(b.hooks))
+    for i, h := range b.hooks {
+        filters[i] = h.filter
+    }
+    return filters
+}
+
+// Starts delivering m to all matching hooks, returns false if none matches.
+// Blocks while the maximum number of requests are in progress,
+// m must not be modified until they are done.
+func (b *Bridge) Handle(m *mqttgo.MsgPublish) bool {
+    matched := false
+    for _, h := range b.hooks {
+        if !mqttgo.MatchTopic(h.filter, m.Topic) {
+            continue
+        }
+        matched = true
+        if b.sem != nil {
+            b.sem <- struct{}{}
+        }
+        b.wg.Add(1)
+        go func(url string) {
+            defer b.wg.Done()
+            if b.sem != nil {
+                defer func() { <-b.sem }()
+            }
+            b.deliver(m, url)
+        }(h.url)
+    }
+    return matched
+}
+
+// Waits for all deliveries in progress, including their retries
+func (b *Bridge) Wait() {
+    b.wg.Wait()
+}
+
+func (b *Bridge) deliver(m *mqttgo.MsgPublish, url string) {
+    backoff := b.Backoff
+    var err error
+    for i := 0; i < b.Attempts || i == 0; i++ {
+        if i > 0 {
+            time.Sleep(backoff)
+            backoff *= 2
+        }
+        var retry bool
+        if retry, err = b.post(m, url); err == nil || !retry {
+            break
+        }
+    }
+    if err == nil || b.DeadLetter == "" || b.send == nil {
+        return
+    }
+    qos, _ := m.H.Qos()
+    dl, jerr := mqttgo.PublishJSON(b.DeadLetter, &DeadLetter{m.Topic, qos, m.H.Retain(),
+        m.Content, url, err.Error()})
+    if jerr == nil {
+        b.send(dl)
+    }
+}
+
+// Makes one request, returns whether a failure is worth retrying
+func (b *Bridge) post(m *mqttgo.MsgPublish, url string) (bool, error) {
+    req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(m.Content))
+    if err != nil {
+        return false, err
+    }
+    qos, _ := m.H.Qos()
+    req.Header.Set("Content-Type", "application/octet-stream")
+    req.Header.Set("X-Mqtt-Topic", m.Topic)
+    req.Header.Set("X-Mqtt-Qos", strconv.Itoa(int(qos)))
+    req.Header.Set("X-Mqtt-Retain", strconv.FormatBool(m.H.Retain()))
+    client := b.Client
+    if client == nil {
+        client = defaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return true, err
+    }
+    io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+    resp.Body.Close()
+    if resp.StatusCode / 100 == 2 {
+        return false, nil
+    }
+    // Other client errors won't succeed on retry
+    retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+    return retry, fmt.Errorf("mqttgo/webhook: %s returned %s", url, resp.Status)
+}
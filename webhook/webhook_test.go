@@ -0,0 +1,174 @@
+// Copyright 2014 mqttgo author
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package webhook
+
+import (
+    "sync"
+    "time"
+    "testing"
+    "net/http"
+    "sync/atomic"
+    "encoding/json"
+    "net/http/httptest"
+    "github.com/oxfeeefeee/mqttgo"
+    )
+
+// Serves the status codes in turn, the last one from then on
+func statusServer(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+    var n int32
+    s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        i := int(atomic.AddInt32(&n, 1)) - 1
+        if i >= len(codes) {
+            i = len(codes) - 1
+        }
+        w.WriteHeader(codes[i])
+    }))
+    t.Cleanup(s.Close)
+    return s, &n
+}
+
+// A bridge with a single hook on url, collecting dead letters
+func testBridge(t *testing.T, url string, concurrency int) (*Bridge, *[]*mqttgo.MsgPublish) {
+    var mu sync.Mutex
+    var dead []*mqttgo.MsgPublish
+    b := NewBridge(concurrency, func(m *mqttgo.MsgPublish) {
+        mu.Lock()
+        defer mu.Unlock()
+        dead = append(dead, m)
+    })
+    b.Attempts = 3
+    b.Backoff = time.Millisecond
+    b.DeadLetter = "dead/letters"
+    if err := b.AddHook("sensors/#", url); err != nil {
+        t.Fatal(err)
+    }
+    return b, &dead
+}
+
+func TestRetry(t *testing.T) {
+    for _, code := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+        s, n := statusServer(t, code, http.StatusOK)
+        b, dead := testBridge(t, s.URL, 1)
+        b.Handle(mqttgo.NewPub("sensors/temp", mqttgo.QosAtLeastOnce, []byte("21.5")))
+        b.Wait()
+        if atomic.LoadInt32(n) != 2 {
+            t.Errorf("%d: %d requests, want 2", code, atomic.LoadInt32(n))
+        }
+        if len(*dead) != 0 {
+            t.Errorf("%d: delivery dead-lettered", code)
+        }
+    }
+}
+
+func TestNoRetry(t *testing.T) {
+    s, n := statusServer(t, http.StatusNotFound, http.StatusOK)
+    b, dead := testBridge(t, s.URL, 1)
+    b.Handle(mqttgo.NewPub("sensors/temp", mqttgo.QosAtLeastOnce, []byte("21.5")))
+    b.Wait()
+    if atomic.LoadInt32(n) != 1 {
+        t.Errorf("%d requests, want 1", atomic.LoadInt32(n))
+    }
+    if len(*dead) != 1 {
+        t.Errorf("%d dead letters, want 1", len(*dead))
+    }
+}
+
+func TestDeadLetter(t *testing.T) {
+    s, n := statusServer(t, http.StatusInternalServerError)
+    b, dead := testBridge(t, s.URL, 1)
+    m := mqttgo.NewPub("sensors/temp", mqttgo.QosAtLeastOnce, []byte("21.5"))
+    m.H.SetRetain(true)
+    b.Handle(m)
+    b.Wait()
+    if atomic.LoadInt32(n) != 3 {
+        t.Errorf("%d requests, want 3", atomic.LoadInt32(n))
+    }
+    if len(*dead) != 1 {
+        t.Fatalf("%d dead letters, want 1", len(*dead))
+    }
+    if (*dead)[0].Topic != "dead/letters" {
+        t.Errorf("dead letter published to %q", (*dead)[0].Topic)
+    }
+    var dl DeadLetter
+    if err := json.Unmarshal((*dead)[0].Content, &dl); err != nil {
+        t.Fatal(err)
+    }
+    if dl.Topic != "sensors/temp" || dl.Qos != mqttgo.QosAtLeastOnce || !dl.Retain ||
+        string(dl.Content) != "21.5" || dl.URL != s.URL || dl.Error == "" {
+        t.Errorf("dead letter %+v", dl)
+    }
+}
+
+func TestConcurrency(t *testing.T) {
+    var mu sync.Mutex
+    var active, max int
+    s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        if active++; active > max {
+            max = active
+        }
+        mu.Unlock()
+        time.Sleep(20 * time.Millisecond)
+        mu.Lock()
+        active--
+        mu.Unlock()
+    }))
+    defer s.Close()
+    b, _ := testBridge(t, s.URL, 2)
+    for i := 0; i < 10; i++ {
+        b.Handle(mqttgo.NewPub("sensors/temp", mqttgo.QosAtMostOnce, nil))
+    }
+    b.Wait()
+    if max != 2 {
+        t.Errorf("%d requests at a time, want 2", max)
+    }
+}
+
+func TestZeroBridge(t *testing.T) {
+    s, n := statusServer(t, http.StatusInternalServerError)
+    b := &Bridge{}
+    b.AddHook("sensors/#", s.URL)
+    if !b.Handle(mqttgo.NewPub("sensors/temp", mqttgo.QosAtMostOnce, nil)) {
+        t.Fatal("hook didn't match")
+    }
+    b.Wait()
+    if atomic.LoadInt32(n) != 1 {
+        t.Errorf("%d requests, want 1", atomic.LoadInt32(n))
+    }
+}
+
+func TestTimeout(t *testing.T) {
+    release := make(chan struct{})
+    s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    }))
+    defer s.Close()
+    defer close(release)
+    if defaultClient.Timeout != DefaultTimeout {
+        t.Errorf("default client timeout %v", defaultClient.Timeout)
+    }
+    b, dead := testBridge(t, s.URL, 1)
+    b.Client = &http.Client{Timeout: 50 * time.Millisecond}
+    b.Attempts = 2
+    for i := 0; i < 2; i++ {
+        // Blocks unless the first delivery gave up its slot
+        b.Handle(mqttgo.NewPub("sensors/temp", mqttgo.QosAtMostOnce, nil))
+    }
+    b.Wait()
+    if len(*dead) != 2 {
+        t.Errorf("%d dead letters, want 2", len(*dead))
+    }
+}